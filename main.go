@@ -9,6 +9,16 @@ import (
 )
 
 func main() {
+	flag.BoolVar(&registry.LENIENT, "lenient", false,
+		"skip unknown and malformed annotations with a warning")
+	flag.BoolVar(&registry.LINE_START, "line-start", false,
+		"recognize annotations only at the start of comment line")
+	flag.StringVar(&registry.TAG_KEY, "tag", registry.TAG_KEY,
+		"the key of struct tag with field annotations (empty to disable)")
+	flag.StringVar(&registry.BUILD_TAGS, "tags", "",
+		"comma-separated list of build tags to consider satisfied during generation")
+	flag.BoolVar(&registry.IGNORE_CASE, "ignore-case", false,
+		"match annotation attributes to the fields of annotation struct ignoring case")
 	flag.Parse()
 	wd, err := os.Getwd()
 	if err != nil {
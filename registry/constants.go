@@ -0,0 +1,266 @@
+package registry
+
+import (
+	"go/ast"
+	"go/constant"
+	"go/token"
+	"go/types"
+	"math"
+	"strconv"
+)
+
+// Evaluates constant expression used as annotation attribute value.
+// Returns the value and its type (nil for untyped values)
+func evalConstant(e *Expr, x ast.Expr) (constant.Value, types.Type) {
+	switch t := x.(type) {
+	case *ast.BasicLit:
+		v := constant.MakeFromLiteral(t.Value, t.Kind, 0)
+		if v.Kind() == constant.Unknown {
+			panic("Incorrect literal " + t.Value + " in '" + e.Text + "'")
+		}
+		return v, nil
+	case *ast.ParenExpr:
+		return evalConstant(e, t.X)
+	case *ast.Ident:
+		switch t.Name {
+		case "true", "false":
+			return constant.MakeBool(t.Name == "true"), nil
+		}
+		return lookupConstant(e, e.Package, t.Name)
+	case *ast.SelectorExpr:
+		pck, name := resolveSelector(e, t)
+		return lookupConstant(e, pck, name)
+	case *ast.UnaryExpr:
+		v, vt := evalConstant(e, t.X)
+		if !isOperatorOf(t.Op, v, true) {
+			panic("Operator " + t.Op.String() + " is not defined on " + v.String() + " in '" + e.Text + "'")
+		}
+		return constant.UnaryOp(t.Op, v, 0), vt
+	case *ast.BinaryExpr:
+		return evalBinary(e, t)
+	}
+	panic("Expression '" + e.Text + "' is not supported as annotation attribute value")
+}
+
+// Returns the value and the type of constant declared in provided package
+func lookupConstant(e *Expr, pck, name string) (constant.Value, types.Type) {
+	obj := loadTypes(pck).Scope().Lookup(name)
+	if obj == nil {
+		panic("'" + name + "' is not found in package '" + pck + "'")
+	}
+	c, ok := obj.(*types.Const)
+	if !ok {
+		panic("'" + name + "' used in '" + e.Text + "' is not a constant")
+	}
+	if isUntyped(c.Type()) {
+		return c.Val(), nil
+	}
+	return c.Val(), c.Type()
+}
+
+// Evaluates binary operation of constant expression
+func evalBinary(e *Expr, x *ast.BinaryExpr) (constant.Value, types.Type) {
+	left, leftType := evalConstant(e, x.X)
+	right, rightType := evalConstant(e, x.Y)
+	switch x.Op {
+	case token.SHL, token.SHR:
+		left = constant.ToInt(left)
+		s, exact := constant.Uint64Val(constant.ToInt(right))
+		if left.Kind() != constant.Int || !exact || s > 1<<16 {
+			panic("Incorrect shift in '" + e.Text + "'")
+		}
+		return constant.Shift(left, x.Op, uint(s)), leftType
+	}
+	if leftType != nil && rightType != nil && !types.Identical(leftType, rightType) {
+		panic("Mismatched types " + leftType.String() + " and " + rightType.String() + " in '" + e.Text + "'")
+	}
+	resultType := leftType
+	if resultType == nil {
+		resultType = rightType
+	}
+	left, right = matchKinds(e, left, right)
+	switch x.Op {
+	case token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+		if !isOperatorOf(x.Op, left, false) {
+			panic("Operator " + x.Op.String() + " is not defined on " + left.String() + " in '" + e.Text + "'")
+		}
+		return constant.MakeBool(constant.Compare(left, x.Op, right)), nil
+	case token.QUO, token.REM:
+		if constant.Sign(right) == 0 {
+			panic("Division by zero in '" + e.Text + "'")
+		}
+	}
+	if !isOperatorOf(x.Op, left, false) {
+		panic("Operator " + x.Op.String() + " is not defined on " + left.String() + " in '" + e.Text + "'")
+	}
+	op := x.Op
+	if op == token.QUO && left.Kind() == constant.Int {
+		// integer division
+		op = token.QUO_ASSIGN
+	}
+	return constant.BinaryOp(left, op, right), resultType
+}
+
+// Converts numeric operands to the same kind (int, float or complex)
+func matchKinds(e *Expr, x, y constant.Value) (constant.Value, constant.Value) {
+	if x.Kind() == y.Kind() {
+		return x, y
+	}
+	if !isNumeric(x) || !isNumeric(y) {
+		panic("Mismatched operands " + x.String() + " and " + y.String() + " in '" + e.Text + "'")
+	}
+	switch {
+	case x.Kind() == constant.Complex || y.Kind() == constant.Complex:
+		return constant.ToComplex(x), constant.ToComplex(y)
+	default:
+		return constant.ToFloat(x), constant.ToFloat(y)
+	}
+}
+
+func isNumeric(v constant.Value) bool {
+	switch v.Kind() {
+	case constant.Int, constant.Float, constant.Complex:
+		return true
+	}
+	return false
+}
+
+// Returns true if provided operator can be applied to the value
+func isOperatorOf(op token.Token, v constant.Value, unary bool) bool {
+	switch v.Kind() {
+	case constant.Bool:
+		if unary {
+			return op == token.NOT
+		}
+		return op == token.LAND || op == token.LOR || op == token.EQL || op == token.NEQ
+	case constant.String:
+		switch op {
+		case token.ADD, token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return !unary
+		}
+	case constant.Int:
+		switch op {
+		case token.ADD, token.SUB, token.XOR:
+			return true
+		case token.MUL, token.QUO, token.REM, token.AND, token.OR, token.AND_NOT,
+			token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return !unary
+		}
+	case constant.Float:
+		switch op {
+		case token.ADD, token.SUB:
+			return true
+		case token.MUL, token.QUO, token.EQL, token.NEQ, token.LSS, token.LEQ, token.GTR, token.GEQ:
+			return !unary
+		}
+	case constant.Complex:
+		switch op {
+		case token.ADD, token.SUB:
+			return true
+		case token.MUL, token.QUO, token.EQL, token.NEQ:
+			return !unary
+		}
+	}
+	return false
+}
+
+func isUntyped(t types.Type) bool {
+	b, ok := t.(*types.Basic)
+	return ok && b.Info()&types.IsUntyped != 0
+}
+
+// Returns the value converted to provided basic type
+// or nil if the value can't be represented by that type
+func representAs(v constant.Value, t *types.Basic) constant.Value {
+	info := t.Info()
+	switch {
+	case info&types.IsBoolean != 0:
+		if v.Kind() == constant.Bool {
+			return v
+		}
+	case info&types.IsString != 0:
+		if v.Kind() == constant.String {
+			return v
+		}
+	case info&types.IsInteger != 0:
+		v = constant.ToInt(v)
+		if v.Kind() != constant.Int {
+			return nil
+		}
+		size := intSize(t)
+		if info&types.IsUnsigned != 0 {
+			u, exact := constant.Uint64Val(v)
+			if exact && constant.Sign(v) >= 0 && (size == 64 || u < 1<<uint(size)) {
+				return v
+			}
+		} else {
+			i, exact := constant.Int64Val(v)
+			if exact && (size == 64 || (i >= -1<<uint(size-1) && i < 1<<uint(size-1))) {
+				return v
+			}
+		}
+	case info&types.IsFloat != 0:
+		v = constant.ToFloat(v)
+		if v.Kind() != constant.Float {
+			return nil
+		}
+		if t.Kind() == types.Float32 {
+			if f, _ := constant.Float32Val(v); !math.IsInf(float64(f), 0) {
+				return v
+			}
+		} else if f, _ := constant.Float64Val(v); !math.IsInf(f, 0) {
+			return v
+		}
+	case info&types.IsComplex != 0:
+		v = constant.ToComplex(v)
+		if v.Kind() == constant.Complex {
+			return v
+		}
+	}
+	return nil
+}
+
+// Returns the size in bits of integer type
+func intSize(t *types.Basic) int {
+	switch t.Kind() {
+	case types.Int8, types.Uint8:
+		return 8
+	case types.Int16, types.Uint16:
+		return 16
+	case types.Int32, types.Uint32:
+		return 32
+	}
+	return 64
+}
+
+// Panics if provided number literal can't be represented by the value of given type
+func checkOverflow(value string, t types.Type, b *types.Basic) {
+	v := parseNumber(value, token.INT)
+	if v.Kind() == constant.Unknown {
+		v = parseNumber(value, token.FLOAT)
+	}
+	if representAs(v, b) == nil {
+		panic("Value '" + value + "' overflows or can't be assigned to annotation field of type " + t.String())
+	}
+}
+
+// Returns Go literal for the value of constant
+func getConstantLiteral(v constant.Value, t *types.Basic) string {
+	switch v.Kind() {
+	case constant.String:
+		return strconv.Quote(constant.StringVal(v))
+	case constant.Float:
+		size := 64
+		if t != nil && t.Kind() == types.Float32 {
+			size = 32
+		}
+		f, _ := constant.Float64Val(v)
+		literal := strconv.FormatFloat(f, 'g', -1, size)
+		if t == nil && isNumber(literal, token.INT) {
+			// keep float as default type
+			literal += ".0"
+		}
+		return literal
+	}
+	return v.ExactString()
+}
@@ -0,0 +1,224 @@
+package registry
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Directive which declares default annotations in the form
+	// //annotations:defaults [kinds] [override|merge] @Annotation...
+	// Kinds are comma-separated kinds of targets the annotations are applied to:
+	// struct, interface, func, field, method (struct, interface and func by default).
+	// Directive in the package doc comment declares package-wide defaults,
+	// in other comments it declares defaults for the file
+	DEFAULTS_DIRECTIVE = "//annotations:defaults"
+)
+
+type (
+	// Annotation applied by default to all matching targets of the package or the file
+	defaultAnnotation struct {
+		AnnotationDoc
+		kinds map[string]bool // kinds of targets the annotation is applied to
+		merge bool            // explicit annotation of the target is merged with default one instead of overriding it
+	}
+)
+
+// Returns package-wide default annotations declared in the package doc comment of provided source file
+func findPackageDefaults(path, file string) []defaultAnnotation {
+	source := filepath.Join(path, file)
+	fset := token.NewFileSet()
+	fileNode, err := parser.ParseFile(fset, source, nil, parser.ParseComments|parser.ImportsOnly)
+	if err != nil {
+		panic("Error while parse source file " + source + ":\n" + err.Error())
+	}
+	if fileNode.Doc == nil {
+		return nil
+	}
+	var foundImports []string
+	aliases := make(map[string]string)
+	for _, is := range fileNode.Imports {
+		processImports(is, &foundImports, aliases)
+	}
+	fullPackage := resolveFullPackage(path, fileNode.Name.Name)
+	defaults := findDefaults([]*ast.CommentGroup{fileNode.Doc}, fset)
+	resolveDefaults(defaults, fullPackage, aliases, source)
+	if LENIENT {
+		defaults = dropUnknownDefaults(defaults, combinePackages(foundImports, []string{fullPackage}), source)
+	}
+	return defaults
+}
+
+// Returns default annotations declared by directives in provided comments
+func findDefaults(comments []*ast.CommentGroup, fset *token.FileSet) []defaultAnnotation {
+	var defaults []defaultAnnotation
+	for _, group := range comments {
+		for i := 0; i < len(group.List); i++ {
+			text := group.List[i].Text
+			if !strings.HasPrefix(text+" ", DEFAULTS_DIRECTIVE+" ") {
+				continue
+			}
+			position := fset.Position(group.List[i].Pos()).String()
+			text = text[len(DEFAULTS_DIRECTIVE):]
+			// directive is continued on the next line after '\'
+			for strings.HasSuffix(strings.TrimSpace(text), "\\") && i+1 < len(group.List) {
+				i++
+				text += "\n" + strings.TrimPrefix(group.List[i].Text, "//")
+			}
+			defaults = append(defaults, parseDefaults(text, position)...)
+		}
+	}
+	return defaults
+}
+
+// Parses options and annotations of defaults directive
+func parseDefaults(text, position string) []defaultAnnotation {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("%s: %v", position, r))
+		}
+	}()
+	options := text
+	if at := strings.Index(text, "@"); at >= 0 {
+		options, text = text[:at], text[at:]
+	}
+	kinds := make(map[string]bool)
+	merge := false
+	for _, option := range strings.Fields(options) {
+		switch option {
+		case "override":
+			merge = false
+		case "merge":
+			merge = true
+		default:
+			for _, kind := range strings.Split(option, ",") {
+				switch kind {
+				case "struct", "interface", "func", "field", "method":
+					kinds[kind] = true
+				case "":
+				default:
+					panic("Unknown option '" + kind + "' of defaults directive")
+				}
+			}
+		}
+	}
+	if len(kinds) == 0 {
+		kinds = map[string]bool{"struct": true, "interface": true, "func": true}
+	}
+	docs := FindAnnotations(text)
+	if len(docs) == 0 {
+		panic("No annotations found in defaults directive")
+	}
+	var defaults []defaultAnnotation
+	setPosition(docs, position)
+	for _, doc := range docs {
+		defaults = append(defaults, defaultAnnotation{doc, kinds, merge})
+	}
+	return defaults
+}
+
+// Resolves package qualifiers and expressions of default annotations
+// in the scope of the source file where they are declared
+func resolveDefaults(defaults []defaultAnnotation, fullPackage string, aliases map[string]string, source string) {
+	for i := range defaults {
+		docs := []AnnotationDoc{defaults[i].AnnotationDoc}
+		resolveReferences(docs, fullPackage, aliases, source)
+		defaults[i].AnnotationDoc = docs[0]
+	}
+}
+
+// Returns only default annotations which structs are found in provided packages
+func dropUnknownDefaults(defaults []defaultAnnotation, possiblePackages []string, source string) []defaultAnnotation {
+	var known []defaultAnnotation
+	for _, d := range defaults {
+		if len(keepKnownAnnotations([]AnnotationDoc{d.AnnotationDoc}, possiblePackages, source, "defaults")) > 0 {
+			known = append(known, d)
+		}
+	}
+	return known
+}
+
+// Applies default annotations to the entry, its fields and methods.
+// Entry of methods declaration contains only methods in its fields.
+// Annotations structs are searched in provided packages
+func applyEntryDefaults(e *AnnotatedEntry, defaults []defaultAnnotation, methodsEntry bool, possiblePackages []string) {
+	if len(defaults) == 0 {
+		return
+	}
+	fieldKind := "field"
+	if methodsEntry {
+		fieldKind = "method"
+	} else {
+		e.Self = applyDefaults(e.Self, defaults, e.Type, possiblePackages)
+	}
+	for field, docs := range e.Fields {
+		e.Fields[field] = applyDefaults(docs, defaults, fieldKind, possiblePackages)
+	}
+	for method, docs := range e.Methods {
+		e.Methods[method] = applyDefaults(docs, defaults, "method", possiblePackages)
+	}
+}
+
+// Returns explicit annotations of the target combined with matching defaults.
+// Default annotation is added if the target has no annotation of the same type,
+// otherwise it is ignored or merged with explicit one according to its policy
+func applyDefaults(docs []AnnotationDoc, defaults []defaultAnnotation, kind string, possiblePackages []string) []AnnotationDoc {
+	result := append([]AnnotationDoc(nil), docs...)
+	for _, d := range defaults {
+		if !d.kinds[kind] {
+			continue
+		}
+		found := false
+		for i := range result {
+			if isSameAnnotation(&result[i], &d.AnnotationDoc) {
+				found = true
+				if d.merge {
+					result[i] = mergeAnnotations(&d.AnnotationDoc, &result[i], possiblePackages)
+				}
+			}
+		}
+		if !found {
+			result = append(result, d.AnnotationDoc)
+		}
+	}
+	return result
+}
+
+// Checks whether provided annotations have the same type.
+// Unqualified annotation matches the annotation with the same name from any package
+func isSameAnnotation(a, b *AnnotationDoc) bool {
+	return a.Name == b.Name && (a.Package == "" || b.Package == "" || a.Package == b.Package)
+}
+
+// Returns explicit annotation which contains the attributes of default one
+// unless they are specified explicitly. Attributes are matched by the fields of
+// annotation struct (searched in provided packages), so the attribute given by
+// its alias or without name overrides the default of the same field
+func mergeAnnotations(def, explicit *AnnotationDoc, possiblePackages []string) AnnotationDoc {
+	merged := *explicit
+	merged.Content = make(map[string]interface{})
+	for k, v := range def.Content {
+		merged.Content[k] = v
+	}
+	// unknown annotation is reported at generation time
+	if obj, _, _ := findAnnotationStruct(explicit.Name, annotationPackages(explicit, possiblePackages)); obj != nil {
+		names, valueField := getAttributeNames(explicit, obj.Type().Underlying().(*types.Struct))
+		for k := range explicit.Content {
+			i, found := findAttribute(k, names, valueField)
+			for defKey := range def.Content {
+				if j, defFound := findAttribute(defKey, names, valueField); found && defFound && i == j {
+					delete(merged.Content, defKey)
+				}
+			}
+		}
+	}
+	for k, v := range explicit.Content {
+		merged.Content[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,185 @@
+package registry
+
+import (
+	"fmt"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	// Extension of external annotations files
+	ANNOTATIONS_FILE_EXT = ".ann"
+)
+
+// Parses external annotations file. It is used to annotate entries
+// which sources can't be changed (generated code, third-party packages).
+// The file starts with Go import declarations followed by the list of targets.
+// Target is the fully qualified name of struct, interface, func or member of struct
+// or interface (like 'net/http.Client' or 'orm.Order.Total' where orm is the alias
+// of imported package). Target starts the line and its annotations follow it
+// in the same line or in the next indented lines. Lines started with '//' are comments.
+// Returns annotated entries and all imports of the file
+// Parameters:
+// - the folder of annotations file and its name
+// - full name of the package where annotations registry is generated
+func ParseAnnotationsFile(path, file, fullPackage string) ([]AnnotatedEntry, []string) {
+	var foundImports []string
+	var foundAnnotations []AnnotatedEntry
+	aliases := make(map[string]string)
+	source := filepath.Join(path, file)
+	content, err := ioutil.ReadFile(source)
+	if err != nil {
+		panic(err)
+	}
+	lines := strings.Split(string(content), "\n")
+	// imports are parsed as Go source
+	first := skipImports(lines)
+	fileNode, err := parser.ParseFile(token.NewFileSet(), source,
+		"package p;"+strings.Join(lines[:first], "\n"), parser.ImportsOnly)
+	if err != nil {
+		panic("Error while parse imports of annotations file " + source + ":\n" + err.Error())
+	}
+	for _, is := range fileNode.Imports {
+		processImports(is, &foundImports, aliases)
+	}
+	for i := first; i < len(lines); {
+		line := strings.TrimSpace(lines[i])
+		if line == "" || strings.HasPrefix(line, "//") {
+			i++
+			continue
+		}
+		if isAnnotationsLine(lines[i]) || strings.HasPrefix(line, "@") {
+			panic(fmt.Sprintf("%s:%d: target is expected before annotations", source, i+1))
+		}
+		// target and its annotations
+		target, text := line, ""
+		if space := strings.IndexAny(line, " \t"); space >= 0 {
+			target, text = line[:space], line[space+1:]
+		}
+		start := i
+		for i++; i < len(lines) && isAnnotationsLine(lines[i]); i++ {
+			if line := strings.TrimSpace(lines[i]); !strings.HasPrefix(line, "//") {
+				text += "\n" + line
+			}
+		}
+		entry := parseExternalEntry(target, text, aliases, fmt.Sprintf("%s:%d", source, start+1))
+		resolveEntryReferences(&entry, fullPackage, aliases, source)
+		foundAnnotations = append(foundAnnotations, entry)
+	}
+	if LENIENT {
+		possiblePackages := combinePackages(foundImports, []string{fullPackage})
+		dropUnknownAnnotations(foundAnnotations, possiblePackages, source)
+	}
+	return dropEmptyEntries(foundAnnotations), foundImports
+}
+
+// Returns the index of the first line after import declarations and comments
+func skipImports(lines []string) int {
+	inImports := false
+	for i, line := range lines {
+		line = strings.TrimSpace(line)
+		switch {
+		case inImports:
+			inImports = line != ")"
+		case line == "" || strings.HasPrefix(line, "//"):
+		case strings.HasPrefix(line, "import"):
+			inImports = strings.HasSuffix(line, "(")
+		default:
+			return i
+		}
+	}
+	return len(lines)
+}
+
+// Checks whether the line continues annotations of the target (it is empty or indented)
+func isAnnotationsLine(line string) bool {
+	return strings.TrimSpace(line) == "" || strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t")
+}
+
+// Creates annotated entry for the target of external annotations file
+// Parameters:
+// - target name
+// - the text with annotations of the target
+// - aliases of imported packages
+// - position of the target in annotations file (for error messages)
+func parseExternalEntry(target, text string, aliases map[string]string, position string) (entry AnnotatedEntry) {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("%s: %v", position, r))
+		}
+	}()
+	docs := FindAnnotations(text)
+	if len(docs) == 0 {
+		panic("No annotations found for target '" + target + "'")
+	}
+	setPosition(docs, position)
+	pck, names := resolveTarget(target, aliases)
+	obj := loadTypes(pck).Scope().Lookup(names[0])
+	entry = AnnotatedEntry{FullPackage: pck, Name: names[0]}
+	switch t := obj.(type) {
+	case *types.Func:
+		entry.Type = "func"
+	case *types.TypeName:
+		switch t.Type().Underlying().(type) {
+		case *types.Struct:
+			entry.Type = "struct"
+		case *types.Interface:
+			entry.Type = "interface"
+		case *types.Signature:
+			entry.Type = "func"
+		default:
+			panic("Type of target '" + target + "' can't be annotated")
+		}
+	default:
+		panic("Target '" + target + "' is not found or it is not a type or a func")
+	}
+	if len(names) == 1 {
+		entry.Self = docs
+		return entry
+	}
+	if member, _, _ := types.LookupFieldOrMethod(obj.Type(), true, obj.Pkg(), names[1]); member == nil || entry.Type == "func" {
+		panic("'" + pck + "." + names[0] + "' has no field or method '" + names[1] + "'")
+	}
+	// methods of structs are stored together with fields
+	if entry.Type == "interface" {
+		entry.Methods = map[string][]AnnotationDoc{names[1]: docs}
+	} else {
+		entry.Fields = map[string][]AnnotationDoc{names[1]: docs}
+	}
+	return entry
+}
+
+// Returns full package name of the target and the names of the entry and its member (if any).
+// The package is either full package name or an alias of imported package
+func resolveTarget(target string, aliases map[string]string) (string, []string) {
+	slash := strings.LastIndex(target, "/")
+	prefix, parts := target[:slash+1], strings.Split(target[slash+1:], ".")
+	// last element of package name may contain dots (like gopkg.in/yaml.v2)
+	for k := 1; k < len(parts); k++ {
+		if len(parts)-k > 2 {
+			continue
+		}
+		pck := prefix + strings.Join(parts[:k], ".")
+		if alias, found := aliases[pck]; found && slash < 0 && k == 1 {
+			return alias, parts[k:]
+		}
+		if packageExists(pck) {
+			return pck, parts[k:]
+		}
+	}
+	panic("Package of target '" + target + "' is not found")
+}
+
+// Checks whether the package with provided full name can be found in GOPATH or standard library
+func packageExists(pck string) bool {
+	if len(findDirs(pck)) > 0 || isExternalTest(pck) {
+		return true
+	}
+	_, err := build.Import(pck, "", build.FindOnly)
+	return err == nil
+}
@@ -4,16 +4,27 @@ import (
 	"go/ast"
 	"go/parser"
 	"go/token"
+	"log"
 	"path/filepath"
+	"reflect"
+	"strconv"
 	"strings"
 )
 
 // Parses provided source file and extract annotations for all objects
 // (structures, interfaces, methods, functions) as annotated entries.
-// Also it returns all found imports and full package name of the parsed file
+// Also it returns all found imports and full package name of the parsed file.
+// Only file-wide default annotations are applied to the entries
 func ParseFile(path, file string) ([]AnnotatedEntry, []string, string) {
+	return parseFile(path, file, nil)
+}
+
+// Parses provided source file the same way as ParseFile does
+// applying provided package-wide default annotations as well
+func parseFile(path, file string, packageDefaults []defaultAnnotation) ([]AnnotatedEntry, []string, string) {
 	var foundImports []string
 	var foundAnnotations []AnnotatedEntry
+	aliases := make(map[string]string)
 	var foundPackage string
 	source := filepath.Join(path, file)
 	fset := token.NewFileSet()
@@ -23,6 +34,8 @@ func ParseFile(path, file string) ([]AnnotatedEntry, []string, string) {
 	}
 	foundPackage = fileNode.Name.Name
 	fullPackage := resolveFullPackage(path, foundPackage)
+	// entries of methods contain only methods annotations
+	methodEntries := make(map[int]bool)
 	for _, decl := range fileNode.Decls {
 		gd, ok := decl.(*ast.GenDecl)
 		if !ok {
@@ -31,9 +44,10 @@ func ParseFile(path, file string) ([]AnnotatedEntry, []string, string) {
 				continue
 			}
 			if fd.Recv == nil {
-				processFunc(fd, &foundAnnotations, fullPackage)
+				processFunc(fd, &foundAnnotations, fullPackage, fset)
 			} else {
-				processMethod(fd, &foundAnnotations, fullPackage)
+				methodEntries[len(foundAnnotations)] = true
+				processMethod(fd, &foundAnnotations, fullPackage, fset)
 			}
 		} else {
 			for _, spec := range gd.Specs {
@@ -43,7 +57,7 @@ func ParseFile(path, file string) ([]AnnotatedEntry, []string, string) {
 					if !ok {
 						continue
 					} else {
-						processImports(is, &foundImports)
+						processImports(is, &foundImports, aliases)
 					}
 				} else {
 					str, ok := ts.Type.(*ast.StructType)
@@ -52,49 +66,280 @@ func ParseFile(path, file string) ([]AnnotatedEntry, []string, string) {
 						if !ok {
 							continue
 						} else {
-							processInterface(ts, intf, &foundAnnotations, fullPackage)
+							processInterface(ts, intf, &foundAnnotations, fullPackage, fset)
 						}
 					} else if str.Incomplete {
 						continue
 					} else {
-						processStruct(ts, str, &foundAnnotations, fullPackage)
+						processStruct(ts, str, &foundAnnotations, fullPackage, fset)
 					}
 				}
 			}
 		}
 	}
-	return foundAnnotations, foundImports, fullPackage
+	for i := range foundAnnotations {
+		resolveEntryReferences(&foundAnnotations[i], fullPackage, aliases, source)
+	}
+	// file-wide defaults take precedence over package-wide ones
+	var fileComments []*ast.CommentGroup
+	for _, c := range fileNode.Comments {
+		if c != fileNode.Doc {
+			fileComments = append(fileComments, c)
+		}
+	}
+	defaults := findDefaults(fileComments, fset)
+	resolveDefaults(defaults, fullPackage, aliases, source)
+	possiblePackages := combinePackages(foundImports, []string{fullPackage})
+	if LENIENT {
+		dropUnknownAnnotations(foundAnnotations, possiblePackages, source)
+		defaults = dropUnknownDefaults(defaults, possiblePackages, source)
+	}
+	// package-wide defaults are already resolved in the scope of their files
+	defaults = append(defaults, packageDefaults...)
+	for i := range foundAnnotations {
+		applyEntryDefaults(&foundAnnotations[i], defaults, methodEntries[i], possiblePackages)
+	}
+	return dropEmptyEntries(foundAnnotations), foundImports, fullPackage
 }
 
-func processImports(is *ast.ImportSpec, foundImports *[]string) {
+// Removes annotations which structs can't be resolved from provided packages.
+// Every removed annotation is reported as a warning
+func dropUnknownAnnotations(entries []AnnotatedEntry, possiblePackages []string, source string) {
+	for i := range entries {
+		e := &entries[i]
+		e.Self = keepKnownAnnotations(e.Self, possiblePackages, source, e.Name)
+		for field, docs := range e.Fields {
+			e.Fields[field] = keepKnownAnnotations(docs, possiblePackages, source, e.Name+"."+field)
+		}
+		for method, docs := range e.Methods {
+			e.Methods[method] = keepKnownAnnotations(docs, possiblePackages, source, e.Name+"."+method)
+		}
+	}
+}
+
+// Removes fields and methods without annotations from provided entries.
+// Entries without remaining annotations are removed as well
+func dropEmptyEntries(entries []AnnotatedEntry) []AnnotatedEntry {
+	var result []AnnotatedEntry
+	for _, e := range entries {
+		found := len(e.Self) > 0
+		for field, docs := range e.Fields {
+			if len(docs) > 0 {
+				found = true
+			} else {
+				delete(e.Fields, field)
+			}
+		}
+		for method, docs := range e.Methods {
+			if len(docs) > 0 {
+				found = true
+			} else {
+				delete(e.Methods, method)
+			}
+		}
+		if found {
+			result = append(result, e)
+		}
+	}
+	return result
+}
+
+// Returns only annotations which structs are found in provided packages
+// Parameters:
+// - list of annotations
+// - list of packages where annotation structs are searched
+// - source file name and annotated target name (for logging purposes)
+func keepKnownAnnotations(docs []AnnotationDoc, possiblePackages []string, source, target string) []AnnotationDoc {
+	var known []AnnotationDoc
+	for _, doc := range docs {
+		ts, _, _ := findAnnotationStruct(doc.Name, annotationPackages(&doc, possiblePackages))
+		if ts != nil {
+			known = append(known, doc)
+		} else {
+			log.Printf("Warning: unknown annotation '@%s' of %s is skipped in %s\n", doc.Name, target, source)
+		}
+	}
+	return known
+}
+
+func processImports(is *ast.ImportSpec, foundImports *[]string, aliases map[string]string) {
 	v := is.Path.Value
 	if strings.HasPrefix(v, "\"") && strings.HasSuffix(v, "\"") {
 		v = v[1 : len(v)-1]
 	}
-	*foundImports = append(*foundImports, v)
+	*foundImports = combinePackages(*foundImports, []string{v})
+	// blank and dot imports are referenced by the package name
+	if is.Name != nil && is.Name.Name != "_" && is.Name.Name != "." {
+		aliases[is.Name.Name] = v
+	} else {
+		aliases[getPackageName(v)] = v
+	}
+}
+
+// Returns the aliases of packages imported by provided source file
+func getFileAliases(source string) map[string]string {
+	fileNode, err := parser.ParseFile(token.NewFileSet(), source, nil, parser.ImportsOnly)
+	if err != nil {
+		panic("Error while parse source file " + source + ":\n" + err.Error())
+	}
+	var foundImports []string
+	aliases := make(map[string]string)
+	for _, is := range fileNode.Imports {
+		processImports(is, &foundImports, aliases)
+	}
+	return aliases
+}
+
+// Resolves package qualifiers and expressions of all annotations of the entry
+// using the package and aliases of the imports from the source file
+func resolveEntryReferences(e *AnnotatedEntry, fullPackage string, aliases map[string]string, source string) {
+	resolveReferences(e.Self, fullPackage, aliases, source)
+	for _, docs := range e.Fields {
+		resolveReferences(docs, fullPackage, aliases, source)
+	}
+	for _, docs := range e.Methods {
+		resolveReferences(docs, fullPackage, aliases, source)
+	}
 }
 
-func processFunc(fd *ast.FuncDecl, foundAnnotations *[]AnnotatedEntry, fullPackage string) {
+// Sets full package name for every qualified annotation including the ones
+// enclosed into attributes values. Unknown qualifier causes panic unless
+// lenient mode is on; in that case the annotation is left unresolved.
+// Expressions in attributes values get the scope of the source file
+func resolveReferences(docs []AnnotationDoc, fullPackage string, aliases map[string]string, source string) {
+	for i := range docs {
+		a := &docs[i]
+		if a.Qualifier != "" {
+			pck, found := aliases[a.Qualifier]
+			if found {
+				a.Package = pck
+			} else if !LENIENT {
+				panic("Unknown package qualifier '" + a.Qualifier + "' of annotation '@" +
+					a.Qualifier + "." + a.Name + "' in " + source)
+			}
+		}
+		for key, value := range a.Content {
+			a.Content[key] = resolveValueReferences(value, fullPackage, aliases, source)
+		}
+	}
+}
+
+// Resolves references in attribute value the same way as resolveReferences does.
+// Returns the resolved value
+func resolveValueReferences(value interface{}, fullPackage string, aliases map[string]string, source string) interface{} {
+	switch t := value.(type) {
+	case AnnotationDoc:
+		child := []AnnotationDoc{t}
+		resolveReferences(child, fullPackage, aliases, source)
+		return child[0]
+	case []AnnotationDoc:
+		resolveReferences(t, fullPackage, aliases, source)
+	case Expr:
+		return Expr{t.Text, fullPackage, aliases}
+	case []Expr:
+		for i := range t {
+			t[i] = Expr{t[i].Text, fullPackage, aliases}
+		}
+	case []interface{}:
+		for i := range t {
+			t[i] = resolveValueReferences(t[i], fullPackage, aliases, source)
+		}
+	case []MapEntry:
+		for i := range t {
+			t[i].Key = resolveValueReferences(t[i].Key, fullPackage, aliases, source)
+			t[i].Value = resolveValueReferences(t[i].Value, fullPackage, aliases, source)
+		}
+	}
+	return value
+}
+
+func processFunc(fd *ast.FuncDecl, foundAnnotations *[]AnnotatedEntry, fullPackage string, fset *token.FileSet) {
 	name := fd.Name.Name
-	doc := fd.Doc.Text()
-	a := FindAnnotations(doc)
-	if len(a) > 0 {
+	a := findCommentAnnotations(fd.Doc, fset)
+	if name != "init" {
 		*foundAnnotations = append(*foundAnnotations,
 			AnnotatedEntry{"func", fullPackage, name, AnnotationsData{a, nil, nil}})
 	}
 }
 
-func processMethod(fd *ast.FuncDecl, foundAnnotations *[]AnnotatedEntry, fullPackage string) {
+func processMethod(fd *ast.FuncDecl, foundAnnotations *[]AnnotatedEntry, fullPackage string, fset *token.FileSet) {
 	name := fd.Name.Name
 	if len(fd.Recv.List) == 1 {
 		tp := getReceiverType(fd.Recv.List[0].Type)
-		doc := fd.Doc.Text()
-		a := FindAnnotations(doc)
-		if len(a) > 0 {
-			fieldsMap := map[string][]AnnotationDoc{name: a}
-			*foundAnnotations = append(*foundAnnotations,
-				AnnotatedEntry{"struct", fullPackage, tp, AnnotationsData{nil, fieldsMap, nil}})
+		a := findCommentAnnotations(fd.Doc, fset)
+		fieldsMap := map[string][]AnnotationDoc{name: a}
+		*foundAnnotations = append(*foundAnnotations,
+			AnnotatedEntry{"struct", fullPackage, tp, AnnotationsData{nil, fieldsMap, nil}})
+	}
+}
+
+// Returns annotations found in provided comment group.
+// Position of the line where each annotation starts is stored in it for error messages
+func findCommentAnnotations(group *ast.CommentGroup, fset *token.FileSet) []AnnotationDoc {
+	if group == nil {
+		return nil
+	}
+	docs, lines := findAnnotations(getCommentText(group, fset))
+	start := fset.Position(group.Pos())
+	for i := range docs {
+		position := token.Position{Filename: start.Filename, Line: start.Line + lines[i]}
+		docs[i].Position = position.String()
+	}
+	return docs
+}
+
+// Returns the text of comment group like CommentGroup.Text does
+// but each line of the text corresponds to the line of the source
+// starting from the first line of the group
+func getCommentText(group *ast.CommentGroup, fset *token.FileSet) string {
+	start := fset.Position(group.Pos()).Line
+	var lines []string
+	for _, c := range group.List {
+		var text []string
+		if strings.HasPrefix(c.Text, "//") {
+			line := c.Text[2:]
+			if isDirective(line) {
+				line = ""
+			}
+			text = []string{strings.TrimPrefix(line, " ")}
+		} else {
+			text = strings.Split(c.Text[2:len(c.Text)-2], "\n")
 		}
+		offset := fset.Position(c.Pos()).Line - start
+		for i, line := range text {
+			for len(lines) <= offset+i {
+				lines = append(lines, "")
+			}
+			lines[offset+i] += line
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Checks whether the text of line comment is the directive like "go:generate"
+// which is not the part of comment text
+func isDirective(text string) bool {
+	for _, prefix := range []string{"line ", "extern ", "export "} {
+		if strings.HasPrefix(text, prefix) {
+			return true
+		}
+	}
+	colon := strings.Index(text, ":")
+	if colon <= 0 || colon+1 >= len(text) {
+		return false
+	}
+	for i := 0; i <= colon+1; i++ {
+		if c := text[i]; i != colon && !('a' <= c && c <= 'z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// Sets source position of provided annotations
+func setPosition(docs []AnnotationDoc, position string) {
+	for i := range docs {
+		docs[i].Position = position
 	}
 }
 
@@ -110,42 +355,52 @@ func getReceiverType(e ast.Expr) string {
 	panic("Unsupported receiver type")
 }
 
-func processStruct(ts *ast.TypeSpec, str *ast.StructType, foundAnnotations *[]AnnotatedEntry, fullPackage string) {
+func processStruct(ts *ast.TypeSpec, str *ast.StructType, foundAnnotations *[]AnnotatedEntry, fullPackage string, fset *token.FileSet) {
 	name := ts.Name.Name
-	doc := ts.Doc.Text()
-	selfAnnotations := FindAnnotations(doc)
+	selfAnnotations := findCommentAnnotations(ts.Doc, fset)
 	fieldsAnnotations := make(map[string][]AnnotationDoc)
 	for _, field := range str.Fields.List {
-		doc := field.Doc.Text()
-		fieldAnnotations := FindAnnotations(doc)
-		if len(fieldAnnotations) > 0 {
+		fieldAnnotations := findCommentAnnotations(field.Doc, fset)
+		if tagAnnotations := FindAnnotations(getTagAnnotations(field)); len(tagAnnotations) > 0 {
+			setPosition(tagAnnotations, fset.Position(field.Tag.Pos()).String())
+			fieldAnnotations = append(fieldAnnotations, tagAnnotations...)
+		}
+		// fields without annotations may get the default ones
+		if len(fieldAnnotations) > 0 || len(field.Names) == 1 {
 			fieldName := getFieldName(field)
 			fieldsAnnotations[fieldName] = fieldAnnotations
 		}
 	}
-	if len(selfAnnotations) > 0 || len(fieldsAnnotations) > 0 {
-		*foundAnnotations = append(*foundAnnotations,
-			AnnotatedEntry{"struct", fullPackage, name,
-				AnnotationsData{selfAnnotations, fieldsAnnotations, nil}})
+	*foundAnnotations = append(*foundAnnotations,
+		AnnotatedEntry{"struct", fullPackage, name,
+			AnnotationsData{selfAnnotations, fieldsAnnotations, nil}})
+}
+
+// Returns the value of struct tag with annotations of provided field
+// (see TAG_KEY option) or empty string if there is no such tag
+func getTagAnnotations(field *ast.Field) string {
+	if TAG_KEY == "" || field.Tag == nil {
+		return ""
+	}
+	tag, err := strconv.Unquote(field.Tag.Value)
+	if err != nil {
+		panic("Incorrect tag " + field.Tag.Value + " of field '" + getFieldName(field) + "'")
 	}
+	return reflect.StructTag(tag).Get(TAG_KEY)
 }
 
-func processInterface(ts *ast.TypeSpec, intf *ast.InterfaceType, foundAnnotations *[]AnnotatedEntry, fullPackage string) {
+func processInterface(ts *ast.TypeSpec, intf *ast.InterfaceType, foundAnnotations *[]AnnotatedEntry, fullPackage string, fset *token.FileSet) {
 	name := ts.Name.Name
-	doc := ts.Doc.Text()
-	selfAnnotations := FindAnnotations(doc)
+	selfAnnotations := findCommentAnnotations(ts.Doc, fset)
 	methodsAnnotations := make(map[string][]AnnotationDoc)
 	for _, method := range intf.Methods.List {
-		doc := method.Doc.Text()
-		methodAnnotations := FindAnnotations(doc)
-		if len(methodAnnotations) > 0 {
+		methodAnnotations := findCommentAnnotations(method.Doc, fset)
+		if len(methodAnnotations) > 0 || len(method.Names) > 0 {
 			methodName := method.Names[0].Name
 			methodsAnnotations[methodName] = methodAnnotations
 		}
 	}
-	if len(selfAnnotations) > 0 || len(methodsAnnotations) > 0 {
-		*foundAnnotations = append(*foundAnnotations,
-			AnnotatedEntry{"interface", fullPackage, name,
-				AnnotationsData{selfAnnotations, nil, methodsAnnotations}})
-	}
+	*foundAnnotations = append(*foundAnnotations,
+		AnnotatedEntry{"interface", fullPackage, name,
+			AnnotationsData{selfAnnotations, nil, methodsAnnotations}})
 }
@@ -11,38 +11,104 @@ import (
 	"strings"
 )
 
+const (
+	// The first line of generated registry source
+	GENERATED_HEADER = "// Code generated by go-annotations. DO NOT EDIT."
+)
+
+type (
+	// Aliases of packages imported by generated registry.
+	// The alias is chosen when the package is referenced by generated code for the first time
+	importAliases struct {
+		own      string            // package of the registry which identifiers are not qualified
+		aliases  map[string]string // aliases by full packages names
+		packages []string          // imported packages in the order of their aliases
+	}
+)
+
 // Generates the code for register a set of annotations within provided package.
+// Annotations from test files are registered in separate test sources:
+// <output name>_test.go for the package and <output name>_x_test.go for external test package.
 // Parameters:
 // - path - the folder where package source files are located;
 // - pck - the shoirt package name;
 // - outName - name of output source file; if it is empty then <package>_annotations.go will be used
 func GenerateRegistry(path, pck, outName string) {
-	// iterate all files within the package (path) and collect all found imports/annotations
-	files, err := ioutil.ReadDir(path)
-	if err != nil {
-		panic(err)
+	if outName == "" {
+		outName = pck + "_annotations.go"
+	} else {
+		if !strings.HasSuffix(outName, ".go") {
+			outName = outName + ".go"
+		}
+	}
+	testName := strings.TrimSuffix(outName, ".go") + "_test.go"
+	xTestName := strings.TrimSuffix(outName, ".go") + "_x_test.go"
+	bp := getBuildPackage(path)
+	sources := selectSources(path, append(append([]string(nil), bp.GoFiles...), bp.CgoFiles...), outName)
+	generateFilesRegistry(path, sources, sources, outName, true)
+	testSources := selectSources(path, bp.TestGoFiles, testName)
+	xTestSources := selectSources(path, bp.XTestGoFiles, xTestName)
+	if len(testSources) > 0 || len(xTestSources) > 0 {
+		// identifiers of test files are resolved while the registries of tests are generated
+		testedPackage = resolveFullPackage(path, bp.Name)
+		defer func() { testedPackage = "" }()
+	}
+	// package-wide default annotations are applied to internal tests as well
+	generateFilesRegistry(path, testSources, append(testSources, sources...), testName, false)
+	generateFilesRegistry(path, xTestSources, xTestSources, xTestName, false)
+}
+
+// Returns provided source files except generated registry
+func selectSources(path string, files []string, outName string) []string {
+	var sources []string
+	for _, fileName := range files {
+		if fileName != outName && !isGeneratedRegistry(filepath.Join(path, fileName)) {
+			sources = append(sources, fileName)
+		}
 	}
+	return sources
+}
+
+// Generates the registry for annotations found in provided source files of the package.
+// Parameters:
+// - path - the folder where package source files are located;
+// - sources - the names of source files;
+// - defaultsSources - the names of source files which package doc declares default annotations;
+// - outName - name of output source file;
+// - withExternal - whether external annotations files are processed
+func generateFilesRegistry(path string, sources, defaultsSources []string, outName string, withExternal bool) {
+	// iterate all files within the package (path) and collect all found imports/annotations
 	var allAnnotations []AnnotatedEntry
 	var allImports []string
 	var foundPackageName string
-	for _, file := range files {
-		fileName := file.Name()
-		if strings.HasSuffix(fileName, ".go") && !strings.HasPrefix(fileName, "_") {
-			foundAnnotations, foundImports, foundPackage := ParseFile(path, fileName)
-			allAnnotations = append(allAnnotations, foundAnnotations...)
-			allImports = combinePackages(allImports, foundImports)
-			foundPackageName = foundPackage
+	// package-wide default annotations are applied to all files
+	var packageDefaults []defaultAnnotation
+	for _, fileName := range defaultsSources {
+		packageDefaults = append(packageDefaults, findPackageDefaults(path, fileName)...)
+	}
+	for _, fileName := range sources {
+		foundAnnotations, foundImports, foundPackage := parseFile(path, fileName, packageDefaults)
+		allAnnotations = append(allAnnotations, foundAnnotations...)
+		allImports = combinePackages(allImports, foundImports)
+		foundPackageName = foundPackage
+	}
+	if withExternal && foundPackageName != "" {
+		// external annotations files are parsed when the package name is known
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			panic(err)
+		}
+		for _, file := range files {
+			fileName := file.Name()
+			if strings.HasSuffix(fileName, ANNOTATIONS_FILE_EXT) && !strings.HasPrefix(fileName, "_") {
+				foundAnnotations, foundImports := ParseAnnotationsFile(path, fileName, foundPackageName)
+				allAnnotations = append(allAnnotations, foundAnnotations...)
+				allImports = combinePackages(allImports, foundImports)
+			}
 		}
 	}
 	if len(allAnnotations) > 0 {
 		combinedAnnotations := combineMethodsAndFields(allAnnotations)
-		if outName == "" {
-			outName = pck + "_annotations.go"
-		} else {
-			if !strings.HasSuffix(outName, ".go") {
-				outName = outName + ".go"
-			}
-		}
 		content := generateRegistry(combinedAnnotations, foundPackageName, allImports)
 		f, err := os.Create(filepath.Join(path, outName))
 		if err != nil {
@@ -60,11 +126,18 @@ func combineMethodsAndFields(all []AnnotatedEntry) []AnnotatedEntry {
 	// group annotations by common struct name
 	chains := make(map[string][]AnnotatedEntry)
 	for _, a := range all {
-		chains[a.Name] = append(chains[a.Name], a)
+		key := a.FullPackage + "." + a.Name
+		chains[key] = append(chains[key], a)
+	}
+	keys := make([]string, 0, len(chains))
+	for key := range chains {
+		keys = append(keys, key)
 	}
-	// combine chains
+	// combine chains in the order of their names, so generated registry is the same between runs
+	sort.Strings(keys)
 	var combinedAnnotations []AnnotatedEntry
-	for _, chain := range chains {
+	for _, key := range keys {
+		chain := chains[key]
 		if len(chain) > 0 {
 			combined := AnnotatedEntry{chain[0].Type, chain[0].FullPackage, chain[0].Name, AnnotationsData{}}
 			for _, a := range chain {
@@ -81,7 +154,7 @@ func combineMethodsAndFields(all []AnnotatedEntry) []AnnotatedEntry {
 	return combinedAnnotations
 }
 
-// Adds all entries from source map to target map, replacing the ones already exist in target (if any).
+// Adds all entries from source map to target map, appending annotations to the ones already exist in target (if any).
 // If target map is nil then new empty map is provided as the target.
 // Returns target map as the result
 func combineMaps(target, source map[string][]AnnotationDoc) map[string][]AnnotationDoc {
@@ -89,7 +162,7 @@ func combineMaps(target, source map[string][]AnnotationDoc) map[string][]Annotat
 		target = make(map[string][]AnnotationDoc)
 	}
 	for k, v := range source {
-		target[k] = v
+		target[k] = append(target[k], v...)
 	}
 	return target
 }
@@ -97,7 +170,18 @@ func combineMaps(target, source map[string][]AnnotationDoc) map[string][]Annotat
 // Gneretates "package" statement
 func generateHeader(packageName string) string {
 	packageName = packageName[strings.LastIndex(packageName, "/")+1:]
-	return "package " + packageName + "\n\n"
+	return GENERATED_HEADER + "\n\npackage " + packageName + "\n\n"
+}
+
+// Checks whether provided source file is generated annotations registry
+func isGeneratedRegistry(source string) bool {
+	f, err := os.Open(source)
+	if err != nil {
+		panic(err)
+	}
+	defer f.Close()
+	line, _ := bufio.NewReader(f).ReadString('\n')
+	return strings.TrimSpace(line) == GENERATED_HEADER
 }
 
 // Returns alias for import name in form of "a" + <import number in list of all imports>
@@ -105,6 +189,26 @@ func genPackageAlias(i int) string {
 	return "a" + strconv.Itoa(i+1)
 }
 
+// Creates aliases of imports for the registry generated in provided package
+func newImportAliases(own string) *importAliases {
+	return &importAliases{own: own, aliases: map[string]string{metaPackage: "_base"}}
+}
+
+// Returns the qualifier like "a1." for identifiers of provided package
+// or empty string for the package of the registry
+func (ia *importAliases) qualifier(pck string) string {
+	if pck == ia.own {
+		return ""
+	}
+	alias, found := ia.aliases[pck]
+	if !found {
+		alias = genPackageAlias(len(ia.packages))
+		ia.aliases[pck] = alias
+		ia.packages = append(ia.packages, pck)
+	}
+	return alias + "."
+}
+
 // Generates all import statements with corresponding aliases
 func generateImports(imports []string) string {
 	var b bytes.Buffer
@@ -122,34 +226,21 @@ func generateImports(imports []string) string {
 	return b.String()
 }
 
-// Replaces full packages names to their aliases to make
-// generated code more readable
-func replaceImports(content string, imports []string) string {
-	// make sure that deeper imports will be replaced first
-	// it is required for correct processing of nested packages
-	sort.Sort(sort.Reverse(sort.StringSlice(imports)))
-	for i, imp := range imports {
-		content = strings.Replace(content, imp, genPackageAlias(i), -1)
-	}
-	return content
-}
-
 // Iterates through prepared data and produces the source code for registry
 func generateRegistry(all []AnnotatedEntry, foundPackage string, foundImports []string) string {
 	var b bytes.Buffer
-	var allImports []string
 	var allValues bytes.Buffer
+	aliases := newImportAliases(foundPackage)
 	for _, a := range all {
-		s, imports := GenerateAnnotationValue(&a, foundPackage, foundImports)
+		s := GenerateAnnotationValue(&a, foundPackage, foundImports, aliases)
 		s = "    _base.Map(" + strconv.Quote(a.FullPackage+"."+a.Name) + ",\n" + s + ")\n"
 		allValues.WriteString(s)
-		allImports = combinePackages(allImports, imports)
 	}
-	content := replaceImports(allValues.String(), allImports)
+	allValues.WriteString(generateMetaAnnotations(all, foundPackage, foundImports, aliases))
 	b.WriteString(generateHeader(foundPackage))
-	b.WriteString(generateImports(allImports))
+	b.WriteString(generateImports(aliases.packages))
 	b.WriteString("func init() {\n")
-	b.WriteString(content)
+	b.WriteString(allValues.String())
 	b.WriteString("\n}\n")
 	return b.String()
 }
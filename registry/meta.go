@@ -0,0 +1,293 @@
+package registry
+
+import (
+	"bytes"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	// Full name of the package with meta-annotations
+	metaPackage = reflect.TypeOf(Target{}).PkgPath()
+
+	// Meta information of annotation structs
+	annotationMetas = make(map[*types.TypeName]*annotationMeta)
+)
+
+type (
+	// Meta information of annotation struct declared by meta-annotations
+	annotationMeta struct {
+		targets     map[string]bool   // allowed targets (nil if targets are not restricted)
+		repeatable  bool              // annotation can be applied to the same target several times
+		annotations []AnnotationDoc   // meta-annotations which structs are found
+		types       []*types.TypeName // structs of meta-annotations
+		imports     []string          // packages imported by the package of annotation struct
+	}
+)
+
+// Panics if provided annotations of the target violate the restrictions
+// declared by meta-annotations of their structs: the annotation is applied
+// to the target of the kind which is not allowed or non-repeatable annotation
+// is applied several times. Parameters are the same as for checkTarget
+func checkAnnotations(docs []AnnotationDoc, kind, target, packageName string, imports []string) {
+	found := make(map[*types.TypeName]bool)
+	for i := range docs {
+		a := &docs[i]
+		obj := checkTarget(a, kind, target, packageName, imports)
+		if found[obj] && !getAnnotationMeta(obj).repeatable {
+			message := "Annotation '@" + a.Name + "' is applied to '" + target + "' several times" +
+				" but it is not declared as @registry.Repeatable"
+			if a.Position != "" {
+				message = a.Position + ": " + message
+			}
+			panic(message)
+		}
+		found[obj] = true
+	}
+}
+
+// Panics if the annotation can't be applied to the target of provided kind
+// according to the meta-annotation Target of its struct
+// Parameters:
+// - annotation of the target
+// - kind of the target: struct, interface, func, method, field or member (either field or method)
+// - the name of the target (for error message)
+// - full package name and imports of the file containing the annotated target
+// Returns type information of the annotation struct
+func checkTarget(a *AnnotationDoc, kind, target, packageName string, imports []string) *types.TypeName {
+	obj, _, _ := getAnnotationStruct(a.Name, annotationPackages(a, combinePackages(imports, []string{packageName})))
+	targets := getAnnotationMeta(obj).targets
+	if targets == nil || targets[kind] || (kind == "member" && (targets["field"] || targets["method"])) {
+		return obj
+	}
+	if kind == "member" {
+		kind = "field"
+	}
+	var allowed []string
+	for k := range targets {
+		allowed = append(allowed, k)
+	}
+	sort.Strings(allowed)
+	message := "Annotation '@" + a.Name + "' can't be applied to " + kind + " '" + target +
+		"', it is allowed only for " + strings.Join(allowed, ", ")
+	if a.Position != "" {
+		message = a.Position + ": " + message
+	}
+	panic(message)
+}
+
+// Returns meta information of the annotation struct declared by meta-annotations
+// Target and Repeatable in its doc comment
+func getAnnotationMeta(obj *types.TypeName) *annotationMeta {
+	if meta, found := annotationMetas[obj]; found {
+		return meta
+	}
+	meta := &annotationMeta{}
+	pck := obj.Pkg().Path()
+	for _, imp := range obj.Pkg().Imports() {
+		meta.imports = append(meta.imports, imp.Path())
+	}
+	for _, doc := range findMetaAnnotations(obj) {
+		metaObj, _, _ := findAnnotationStruct(doc.Name, annotationPackages(&doc, combinePackages(meta.imports, []string{pck})))
+		if metaObj != nil {
+			meta.annotations = append(meta.annotations, doc)
+			meta.types = append(meta.types, metaObj)
+		}
+		if doc.Package != metaPackage {
+			if metaObj == nil {
+				log.Printf("Warning: unknown annotation '@%s' of annotation '%s' is skipped\n", doc.Name, obj.Name())
+			}
+			continue
+		}
+		switch doc.Name {
+		case "Target":
+			if meta.targets != nil {
+				panic("Targets of annotation '" + obj.Name() + "' are declared twice")
+			}
+			meta.targets = make(map[string]bool)
+			for _, kind := range getTargetKinds(&doc) {
+				switch kind {
+				case "struct", "interface", "func", "method", "field", "const", "package":
+					meta.targets[kind] = true
+				default:
+					panic("Unknown target '" + kind + "' of annotation '" + obj.Name() + "'")
+				}
+			}
+		case "Repeatable":
+			meta.repeatable = true
+		default:
+			message := "Unknown meta-annotation '@" + doc.Qualifier + "." + doc.Name + "' of annotation '" + obj.Name() + "'"
+			if similar := findSimilar(doc.Name, []string{"Target", "Repeatable"}); similar != "" {
+				message += ", did you mean '" + similar + "'?"
+			}
+			if doc.Position != "" {
+				message = doc.Position + ": " + message
+			}
+			panic(message)
+		}
+	}
+	annotationMetas[obj] = meta
+	return meta
+}
+
+// Generates the registration of meta-annotations for the structs of provided annotations
+// and the structs of their meta-annotations (annotations composed of other annotations).
+// Parameters:
+// - annotated entries
+// - full package name where registry is generated
+// - list of imports found in the files of annotated entries
+// - aliases of packages imported by generated registry
+func generateMetaAnnotations(all []AnnotatedEntry, packageName string, foundImports []string, aliases *importAliases) string {
+	var queue []*types.TypeName
+	add := func(docs []AnnotationDoc) {
+		for i := range docs {
+			possiblePackages := annotationPackages(&docs[i], combinePackages(foundImports, []string{packageName}))
+			obj, _, _ := getAnnotationStruct(docs[i].Name, possiblePackages)
+			queue = append(queue, obj)
+		}
+	}
+	for _, a := range all {
+		add(a.Self)
+		for _, field := range sortedNames(a.Fields) {
+			add(a.Fields[field])
+		}
+		for _, method := range sortedNames(a.Methods) {
+			add(a.Methods[method])
+		}
+	}
+	var b bytes.Buffer
+	registered := make(map[string]bool)
+	for len(queue) > 0 {
+		obj := queue[0]
+		queue = queue[1:]
+		name := getAnnotationTypeName(obj)
+		if registered[name] {
+			continue
+		}
+		registered[name] = true
+		meta := getAnnotationMeta(obj)
+		if len(meta.annotations) == 0 {
+			continue
+		}
+		queue = append(queue, meta.types...)
+		pck := obj.Pkg().Path()
+		checkAnnotations(meta.annotations, "struct", obj.Name(), pck, meta.imports)
+		b.WriteString("    _base.MapMeta(" + strconv.Quote(name) + ", []interface{} {\n")
+		for i := range meta.annotations {
+			b.WriteString(generateStruct(&meta.annotations[i], pck, meta.imports, aliases, "        "))
+			b.WriteString(",\n")
+		}
+		b.WriteString("    })\n")
+	}
+	return b.String()
+}
+
+// Returns full name of the type of annotation values (the name of aliased type for type alias)
+func getAnnotationTypeName(obj *types.TypeName) string {
+	if named, ok := obj.Type().(*types.Named); ok {
+		obj = named.Obj()
+	}
+	return obj.Pkg().Path() + "." + obj.Name()
+}
+
+// Returns the kinds of targets listed in meta-annotation Target
+func getTargetKinds(meta *AnnotationDoc) []string {
+	for key, value := range meta.Content {
+		if key != DEFAULT_PARAM && key != "Kinds" {
+			panic("Meta-annotation '@Target' has no attribute '" + key + "'")
+		}
+		switch v := value.(type) {
+		case string:
+			return []string{v}
+		case []string:
+			return v
+		}
+		panic("Targets should be the list of strings like @registry.Target({\"field\", \"method\"})")
+	}
+	panic("Targets are not specified in meta-annotation '@Target'")
+}
+
+// Returns annotations found in the doc comment of the annotation struct declaration.
+// Package qualifiers of these annotations are resolved in the scope of its source file.
+// The doc comment is mostly prose (like e-mail addresses), so in both modes malformed
+// annotations and annotations with unknown package qualifiers are reported as a warning and ignored
+func findMetaAnnotations(obj *types.TypeName) (docs []AnnotationDoc) {
+	source := typesFileSet.Position(obj.Pos()).Filename
+	if source == "" {
+		return nil
+	}
+	if LENIENT {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Warning: annotations of '%s' are ignored: %v\n", obj.Name(), r)
+				docs = nil
+			}
+		}()
+	}
+	lenient := LENIENT
+	LENIENT = true
+	defer func() { LENIENT = lenient }()
+	fset := token.NewFileSet()
+	fileNode, err := parser.ParseFile(fset, source, nil, parser.ParseComments)
+	if err != nil {
+		panic("Error while parse source file " + source + ":\n" + err.Error())
+	}
+	var foundImports []string
+	aliases := make(map[string]string)
+	for _, is := range fileNode.Imports {
+		processImports(is, &foundImports, aliases)
+	}
+	for _, decl := range fileNode.Decls {
+		gd, ok := decl.(*ast.GenDecl)
+		if !ok {
+			continue
+		}
+		for _, spec := range gd.Specs {
+			ts, ok := spec.(*ast.TypeSpec)
+			if !ok || ts.Name.Name != obj.Name() {
+				continue
+			}
+			doc := ts.Doc
+			if doc == nil && len(gd.Specs) == 1 {
+				doc = gd.Doc
+			}
+			found := findCommentAnnotations(doc, fset)
+			resolveReferences(found, obj.Pkg().Path(), aliases, source)
+			for _, a := range found {
+				if a.Qualifier != "" && a.Package == "" {
+					log.Printf("Warning: annotation '@%s.%s' of annotation '%s' has unknown package qualifier and is skipped\n",
+						a.Qualifier, a.Name, obj.Name())
+					continue
+				}
+				docs = append(docs, a)
+			}
+			return docs
+		}
+	}
+	return nil
+}
+
+// Returns the kind of struct member: field or method.
+// If the member is not found (like the method declared in test file) "member" is returned
+func getMemberKind(pck, name, member string) string {
+	if !packageExists(pck) {
+		// external test package
+		return "member"
+	}
+	if obj := loadTypes(pck).Scope().Lookup(name); obj != nil {
+		switch m, _, _ := types.LookupFieldOrMethod(obj.Type(), true, obj.Pkg(), member); m.(type) {
+		case *types.Var:
+			return "field"
+		case *types.Func:
+			return "method"
+		}
+	}
+	return "member"
+}
@@ -0,0 +1,25 @@
+package registry
+
+var (
+	// When lenient mode is on only annotations whose struct can be resolved
+	// from the imports of the annotated file are taken into account.
+	// Unknown or malformed annotations are reported as warnings and skipped
+	LENIENT = false
+
+	// When set the annotation is recognized only if it starts the comment line
+	// (leading spaces are allowed) or directly follows another annotation
+	LINE_START = false
+
+	// The key of struct tag which contains annotations of the field in addition
+	// to its comment, like `ann:"@Column(Name=\"name\") @NotNull"`.
+	// Empty key disables annotations in struct tags
+	TAG_KEY = "ann"
+
+	// Comma or space separated list of build tags which are taken into account
+	// when the sources of annotated package and annotations packages are selected
+	BUILD_TAGS = ""
+
+	// When set the names of annotation attributes are matched to the names
+	// of annotation struct fields (or their tags) ignoring case
+	IGNORE_CASE = false
+)
@@ -1,6 +1,11 @@
 package registry
 
 import (
+	"go/parser"
+	"go/token"
+	"log"
+	"strconv"
+	"strings"
 	"unicode"
 )
 
@@ -39,21 +44,183 @@ var (
 )
 
 // Returns objects for all found annotations in provided comment
-// Objects contain only the map of attribute names and associated values
+// Objects contain only the map of attribute names and associated values.
+// Annotation can be continued on the next lines inside its parameters list or after
+// '\' at the end of line. Annotations inside code blocks (lines indented by tab or
+// at least 4 spaces) are ignored and '@@' is used for literal '@' symbol
 func FindAnnotations(doc string) []AnnotationDoc {
+	annotations, _ := findAnnotations(doc)
+	return annotations
+}
+
+// Returns the same annotations as FindAnnotations and the number of line
+// (starting from 0) where each annotation starts in provided comment
+func findAnnotations(doc string) ([]AnnotationDoc, []int) {
 	var annotations []AnnotationDoc
-	chars := []rune(doc)
+	var lines []int
+	chars := []rune(stripDecoration(doc))
 	n := len(chars)
+	indent := commonIndent(chars, n)
+	lineStart := true
+	codeLine := isCodeLine(chars, 0, n, indent)
+	line := 0
 	for index := 0; index < n; index++ {
-		if chars[index] == '@' {
-			a, pos := parseAnnotation(chars, index+1, n)
-			if a != nil {
-				annotations = append(annotations, *a)
-				index = pos - 1
+		switch c := chars[index]; {
+		case c == '\n':
+			line++
+			lineStart = true
+			codeLine = isCodeLine(chars, index+1, n, indent)
+		case codeLine:
+			// text of code block is not parsed
+		case c == '@':
+			if index+1 < n && chars[index+1] == '@' {
+				// escaped '@' symbol
+				index++
+				lineStart = false
+				continue
 			}
+			if canStartAnnotation(chars, index, lineStart) {
+				a, pos := tryParseAnnotation(chars, index+1, n)
+				if a != nil {
+					annotations = append(annotations, *a)
+					lines = append(lines, line)
+					// annotation can be continued on the next lines
+					line += strings.Count(string(chars[index:pos]), "\n")
+					index = pos - 1
+					// the next annotation may follow on the same line
+					lineStart = true
+					continue
+				}
+			}
+			lineStart = false
+		case unicode.IsSpace(c):
+			// leading spaces don't change the line start state
+		case lineStart && skipListMarker(chars, index, n) > index:
+			// list item starts the line as well
+			index = skipListMarker(chars, index, n) - 1
+		default:
+			lineStart = false
 		}
 	}
-	return annotations
+	return annotations, lines
+}
+
+// Removes '*' decoration from each line of block comment (like ' * @Entity')
+// if all non-empty lines have it
+func stripDecoration(doc string) string {
+	lines := strings.Split(doc, "\n")
+	for _, line := range lines {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed != "" && !strings.HasPrefix(trimmed, "*") {
+			return doc
+		}
+	}
+	for i, line := range lines {
+		line = strings.TrimPrefix(strings.TrimLeft(line, " \t"), "*")
+		lines[i] = strings.TrimPrefix(line, " ")
+	}
+	return strings.Join(lines, "\n")
+}
+
+// Returns the length of indentation common for all non-empty lines
+func commonIndent(chars []rune, n int) int {
+	common := -1
+	for pos := 0; pos < n; {
+		indent := 0
+		for pos+indent < n && (chars[pos+indent] == ' ' || chars[pos+indent] == '\t') {
+			indent++
+		}
+		pos += indent
+		if pos < n && chars[pos] != '\n' && (common < 0 || indent < common) {
+			common = indent
+		}
+		for pos < n && chars[pos] != '\n' {
+			pos++
+		}
+		pos++
+	}
+	if common < 0 {
+		return 0
+	}
+	return common
+}
+
+// Checks whether the line starting from provided position belongs to code block.
+// Such line is indented by tab or at least 4 spaces relative to common indentation
+// and it is not the item of a list
+func isCodeLine(chars []rune, pos, n, indent int) bool {
+	pos += indent
+	spaces := 0
+	for ; pos < n && (chars[pos] == ' ' || chars[pos] == '\t'); pos++ {
+		if chars[pos] == '\t' {
+			spaces += 4
+		} else {
+			spaces++
+		}
+	}
+	if spaces < 4 || pos >= n || chars[pos] == '\n' {
+		return false
+	}
+	return skipListMarker(chars, pos, n) == pos
+}
+
+// Returns the index of next char after list marker ('-', '*', '+', '•', '1.' or '1)'
+// followed by space) at provided position, or the position itself if there is no marker
+func skipListMarker(chars []rune, pos, n int) int {
+	end := pos
+	switch {
+	case end < n && strings.ContainsRune("-*+•", chars[end]):
+		end++
+	default:
+		for end < n && unicode.IsDigit(chars[end]) {
+			end++
+		}
+		if end == pos || end >= n || (chars[end] != '.' && chars[end] != ')') {
+			return pos
+		}
+		end++
+	}
+	if end < n && (chars[end] == ' ' || chars[end] == '\t') {
+		return end + 1
+	}
+	return pos
+}
+
+// Checks whether symbol '@' at provided position can start an annotation.
+// With LINE_START option it should be the first symbol in the line.
+// In lenient mode it should not be the part of a word (like in e-mail address)
+func canStartAnnotation(chars []rune, index int, lineStart bool) bool {
+	if LINE_START && !lineStart {
+		return false
+	}
+	if LENIENT && index > 0 && !unicode.IsSpace(chars[index-1]) {
+		return false
+	}
+	return true
+}
+
+// Parses one annotation the same way as parseAnnotation does.
+// In lenient mode malformed annotation is reported as a warning
+// and nil is returned instead of panic
+func tryParseAnnotation(chars []rune, index, n int) (a *AnnotationDoc, pos int) {
+	if LENIENT {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Warning: malformed annotation is skipped at '@%s': %v\n", restOfLine(chars, index, n), r)
+				a, pos = nil, index
+			}
+		}()
+	}
+	return parseAnnotation(chars, index, n)
+}
+
+// Returns the text from provided position till the end of line
+func restOfLine(chars []rune, pos, n int) string {
+	end := pos
+	for end < n && chars[end] != '\n' {
+		end++
+	}
+	return string(chars[pos:end])
 }
 
 // Parses one annotation from the position where @ symbol is appeared
@@ -66,38 +233,55 @@ func parseAnnotation(chars []rune, index, n int) (*AnnotationDoc, int) {
 	if pos == index {
 		return nil, pos
 	}
+	// split package qualifier
+	var qualifier string
+	if dot := strings.Index(name, "."); dot >= 0 {
+		qualifier, name = name[:dot], name[dot+1:]
+	}
 	// check for reserved word
-	_, found := reserved[name]
-	if found {
-		panic("Reserved word '" + name + "' can't be used as annotation")
+	for _, part := range []string{qualifier, name} {
+		_, found := reserved[part]
+		if found {
+			panic("Reserved word '" + part + "' can't be used as annotation")
+		}
 	}
 	// parse parameters
 	params, pos := parseParameters(chars, pos, n)
-	return &AnnotationDoc{name, params}, pos
+	return &AnnotationDoc{Name: name, Content: params, Qualifier: qualifier}, pos
 }
 
 // Parses annotation name starting from provided position.
+// The name can be qualified by package alias like 'orm.Entity'.
 // Returns found name and index of next char after name.
 // Empty name means that no correct name was found
 func parseAnnotationName(chars []rune, pos, n int) (string, int) {
-	if pos < n {
-		start := pos
-		if unicode.IsLetter(chars[pos]) {
-			pos++
-			for pos < n {
-				c := chars[pos]
-				if unicode.IsLetter(c) ||
-					unicode.IsDigit(c) {
-					pos++
-				} else {
-					break
-				}
+	start := pos
+	pos = parseIdentifier(chars, pos, n)
+	if pos > start && pos+1 < n && chars[pos] == '.' {
+		// qualified name is recognized only if identifier follows the dot
+		if end := parseIdentifier(chars, pos+1, n); end > pos+1 {
+			pos = end
+		}
+	}
+	return string(chars[start:pos]), pos
+}
+
+// Returns the index of next char after identifier starting from provided position.
+// Identifier starts from letter or underscore and contains letters, digits and underscores.
+// If there is no identifier at provided position then the position itself is returned
+func parseIdentifier(chars []rune, pos, n int) int {
+	if pos < n && (unicode.IsLetter(chars[pos]) || chars[pos] == '_') {
+		pos++
+		for pos < n {
+			c := chars[pos]
+			if unicode.IsLetter(c) || unicode.IsDigit(c) || c == '_' {
+				pos++
+			} else {
+				break
 			}
 		}
-		return string(chars[start:pos]), pos
-	} else {
-		return "", pos
 	}
+	return pos
 }
 
 // Parses (optoinal) list of parameters of the annotation.
@@ -105,9 +289,10 @@ func parseAnnotationName(chars []rune, pos, n int) (string, int) {
 // and the index of the next character after the end of annotation
 func parseParameters(chars []rune, pos, n int) (map[string]interface{}, int) {
 	params := make(map[string]interface{})
-	t, quoted, index := getToken(chars, pos, n)
-	if !quoted && t == "(" {
-		index = parseOneParamOrList(chars, index, n, params)
+	// parameters should start on the same line with annotation name
+	index := skipLineSpaces(chars, pos, n)
+	if index < n && chars[index] == '(' {
+		index = parseOneParamOrList(chars, index+1, n, params)
 		t, quoted, index := getToken(chars, index, n)
 		if !quoted && t == ")" {
 			return params, index
@@ -120,134 +305,183 @@ func parseParameters(chars []rune, pos, n int) (map[string]interface{}, int) {
 	}
 }
 
-func parseOneParamOrList(chars []rune, pos, n int, params map[string]interface{}) int {
-	t, quoted, index := getToken(chars, pos, n)
-	if quoted {
-		// it is only one parameter represented as value
-		params[DEFAULT_PARAM] = t
-		return index
-	} else {
-		switch t {
-		case "@":
-			// one element represented as another annotation
-			var v *AnnotationDoc
-			v, index = parseAnnotation(chars, index, n)
-			if v == nil {
-				panic("Incorrect parameter format at '" + string(chars[pos:]) + "'")
+// Returns the index of the first char which is not a space in the same line.
+// Line continuation is considered as a space
+func skipLineSpaces(chars []rune, pos, n int) int {
+	for pos < n {
+		switch chars[pos] {
+		case ' ', '\t', '\r', '\f':
+			pos++
+		case '\\':
+			next := skipContinuation(chars, pos, n)
+			if next == pos {
+				return pos
 			}
-			params[DEFAULT_PARAM] = *v
-			return index
-		case "{":
-			// one element - array of parameters in {}
-			var v interface{}
-			v, index = parseArrayParams(chars, index, n)
-			params[DEFAULT_PARAM] = v
-			return index
-		case "}", "(", ")", ",", "=":
-			// incorrect parameters list
-			panic("Unexpected '" + string(t) + "' in parameters list at '" + string(chars[pos:]) + "'")
-		case "":
-			panic("Unexpected enf of parameters list at '" + string(chars[pos:]) + "'")
+			pos = next
 		default:
-			// parameter name, '=value[,...]' is expected
-			return parseParamList(chars, pos, n, params)
+			return pos
 		}
 	}
+	return pos
 }
 
-func parseArrayParams(chars []rune, pos, n int) (interface{}, int) {
+// Returns the index of next char after line continuation ('\' at the end of line)
+// or provided position if there is no line continuation
+func skipContinuation(chars []rune, pos, n int) int {
+	i := pos + 1
+	for i < n && (chars[i] == ' ' || chars[i] == '\t' || chars[i] == '\r') {
+		i++
+	}
+	if i < n && chars[i] == '\n' {
+		return i + 1
+	}
+	return pos
+}
+
+func parseOneParamOrList(chars []rune, pos, n int, params map[string]interface{}) int {
 	t, quoted, index := getToken(chars, pos, n)
-	if quoted {
-		return parseQuotedArrayParams(t, chars, index, n)
-	} else {
+	if !quoted {
 		switch t {
-		case "@":
-			// array of tokens
-			var v *AnnotationDoc
-			v, index = parseAnnotation(chars, index, n)
-			if v == nil {
-				panic("Incorrect parameter format at '" + string(chars[pos:]) + "'")
-			}
-			return parseAnnotationArrayParams(v, chars, index, n)
-		case "{":
-			panic("Array of arrays parameters are not supported")
-		case "}", "(", ")", ",", "=":
-			panic("Unexpected '" + string(t) + "' in parameters array at '" + string(chars[pos:]) + "'")
+		case "}", ")", ",", "=":
+			// incorrect parameters list
+			panic("Unexpected '" + string(t) + "' in parameters list at '" + string(chars[pos:]) + "'")
 		case "":
 			panic("Unexpected enf of parameters list at '" + string(chars[pos:]) + "'")
+		case "@", "{", "(":
+			// annotation, array or parenthesized expression
 		default:
-			// array of numbers
-			return parseUnquotedArrayParams(t, chars, index, n)
+			next, nextQuoted, _ := getToken(chars, index, n)
+			if !nextQuoted && next == "=" {
+				// parameter name, '=value[,...]' is expected
+				return parseParamList(chars, pos, n, params)
+			}
 		}
 	}
+	// the parameter without name which can be followed by named parameters
+	var v interface{}
+	v, index = parseParamValue(chars, pos, n)
+	params[DEFAULT_PARAM] = v
+	if t, quoted, next := getToken(chars, index, n); !quoted && t == "," {
+		return parseParamList(chars, next, n, params)
+	}
+	return index
 }
 
-func parseQuotedArrayParams(first string, chars []rune, pos, n int) (interface{}, int) {
-	result := []string{first}
+// Parses the array of parameters like {"a", "b"} or the map of parameters
+// starting from the position after '{'. Array elements can be of any kind including
+// nested arrays. Empty braces {} give an empty array which can be assigned to a map as well.
+// Returns the array or the map and the index of next char after it
+func parseArrayParams(chars []rune, pos, n int) (interface{}, int) {
 	t, quoted, index := getToken(chars, pos, n)
-	for !quoted && t == "," {
+	if !quoted && t == "}" {
+		return []interface{}{}, index
+	}
+	if next, nextQuoted, afterNext := getToken(chars, index, n); !nextQuoted && next == ":" {
+		// map of key-value pairs
+		return parseMapParams(getMapKey(t, quoted, chars, pos), chars, afterNext, n)
+	}
+	var values []interface{}
+	index = pos
+	for {
+		var v interface{}
+		v, index = parseParamValue(chars, index, n)
+		values = append(values, v)
 		t, quoted, index = getToken(chars, index, n)
-		if quoted {
-			result = append(result, t)
-			t, quoted, index = getToken(chars, index, n)
-		} else {
-			panic("Array of parameters should not contain different types")
+		if !quoted && t == "}" {
+			return narrowArray(values), index
+		}
+		if quoted || t != "," {
+			panic("No closing '}' in array of parameters at '" + string(chars[pos:]) + "'")
 		}
 	}
-	if !quoted && t == "}" {
-		return result, index
-	}
-	panic("No closing '}' in array of parameters at '" + string(chars[pos:]) + "'")
 }
 
-func parseAnnotationArrayParams(first *AnnotationDoc, chars []rune, pos, n int) (interface{}, int) {
-	result := []AnnotationDoc{*first}
-	t, quoted, index := getToken(chars, pos, n)
-	for !quoted && t == "," {
-		t, quoted, index = getToken(chars, index, n)
-		if quoted || t != "@" {
-			panic("Array of parameters should not contain different types")
+// Returns the array of values of the same kind as []string, []Literal, []Expr
+// or []AnnotationDoc (for annotations of the same type).
+// Arrays of different kinds of values are returned as is
+func narrowArray(values []interface{}) interface{} {
+	switch first := values[0].(type) {
+	case string:
+		result := make([]string, len(values))
+		for i, v := range values {
+			s, ok := v.(string)
+			if !ok {
+				return values
+			}
+			result[i] = s
 		}
-		var v *AnnotationDoc
-		v, index = parseAnnotation(chars, index, n)
-		if v == nil {
-			panic("Incorrect parameter format at '" + string(chars[pos:]) + "'")
+		return result
+	case Literal:
+		result := make([]Literal, len(values))
+		for i, v := range values {
+			l, ok := v.(Literal)
+			if !ok {
+				return values
+			}
+			result[i] = l
 		}
-		if v.Name == first.Name {
-			result = append(result, *v)
-			t, quoted, index = getToken(chars, index, n)
-		} else {
-			panic("Array of parameters should not contain different types")
+		return result
+	case Expr:
+		result := make([]Expr, len(values))
+		for i, v := range values {
+			e, ok := v.(Expr)
+			if !ok {
+				return values
+			}
+			result[i] = e
 		}
+		return result
+	case AnnotationDoc:
+		result := make([]AnnotationDoc, len(values))
+		for i, v := range values {
+			a, ok := v.(AnnotationDoc)
+			if !ok || a.Name != first.Name || a.Qualifier != first.Qualifier {
+				return values
+			}
+			result[i] = a
+		}
+		return result
 	}
-	if !quoted && t == "}" {
-		return result, index
-	}
-	panic("No closing '}' in array of parameters at '" + string(chars[pos:]) + "'")
+	return values
 }
 
-func parseUnquotedArrayParams(first string, chars []rune, pos, n int) (interface{}, int) {
-	result := []string{first}
-	t, quoted, index := getToken(chars, pos, n)
-	for !quoted && t == "," {
+// Parses the map of parameters like {"env": "prod", "tier": "web"}
+// starting from the value of the first key.
+// Returns the list of map entries and the index of next char after the map
+func parseMapParams(firstKey interface{}, chars []rune, pos, n int) (interface{}, int) {
+	var result []MapEntry
+	key := firstKey
+	index := pos
+	for {
+		var value interface{}
+		value, index = parseParamValue(chars, index, n)
+		result = append(result, MapEntry{key, value})
+		t, quoted, next := getToken(chars, index, n)
+		if !quoted && t == "}" {
+			return result, next
+		}
+		if quoted || t != "," {
+			panic("No closing '}' in map of parameters at '" + string(chars[pos:]) + "'")
+		}
+		t, quoted, index = getToken(chars, next, n)
+		key = getMapKey(t, quoted, chars, next)
 		t, quoted, index = getToken(chars, index, n)
-		if quoted {
-			panic("Array of parameters should not contain different types")
-		} else {
-			switch t {
-			case "(", ")", ",", "{", "}", "@", "=":
-				panic("Array of parameters has incorrect format")
-			case "":
-				panic("Unexpected enf of parameters list at '" + string(chars[pos:]) + "'")
-			}
-			result = append(result, t)
-			t, quoted, index = getToken(chars, index, n)
+		if quoted || t != ":" {
+			panic("Colon is absent after map key at '" + string(chars[next:]) + "'")
 		}
 	}
-	if !quoted && t == "}" {
-		return result, index
+}
+
+// Returns the value of map key by its token
+func getMapKey(t string, quoted bool, chars []rune, pos int) interface{} {
+	if quoted {
+		return t
+	}
+	switch t {
+	case "{", "}", "(", ")", "@", ",", "=", ":", "":
+		panic("Map key is absent at '" + string(chars[pos:]) + "'")
 	}
-	panic("No closing '}' in array of parameters at '" + string(chars[pos:]) + "'")
+	return getUnquotedValue(t)
 }
 
 func parseParamList(chars []rune, pos, n int, params map[string]interface{}) int {
@@ -286,83 +520,152 @@ func parseParamName(chars []rune, pos, n int) (string, int) {
 
 func parseParamValue(chars []rune, pos, n int) (interface{}, int) {
 	t, quoted, index := getToken(chars, pos, n)
-	if quoted {
-		return t, index
+	if !quoted {
+		switch t {
+		case "@":
+			var v *AnnotationDoc
+			v, index = parseAnnotation(chars, index, n)
+			if v == nil {
+				panic("Incorrect parameter value format at '" + string(chars[pos:]) + "'")
+			}
+			return *v, index
+		case "{":
+			var v interface{}
+			v, index = parseArrayParams(chars, index, n)
+			return v, index
+		case "}", ")", ",", "=", "":
+			panic("Incorrect parameter value at '" + string(chars[pos:]) + "'")
+		}
 	}
-	switch t {
-	case "@":
-		var v *AnnotationDoc
-		v, index = parseAnnotation(chars, index, n)
-		if v == nil {
-			panic("Incorrect parameter value format at '" + string(chars[pos:]) + "'")
+	text, end := scanExpression(chars, pos, n)
+	if t != "(" && strings.TrimSpace(string(chars[index:end])) == "" {
+		// single value
+		if quoted {
+			return t, index
 		}
-		return *v, index
-	case "{":
-		var v interface{}
-		v, index = parseArrayParams(chars, index, n)
-		return v, index
-	case "}", "(", ")", ",", "=", "":
-		panic("Incorrect parameter value at '" + string(chars[pos:]) + "'")
-	default:
-		return t, index
+		return getUnquotedValue(t), index
+	}
+	// constant expression like 60*60 or "part one " + "part two"
+	if _, err := parser.ParseExpr(text); err != nil {
+		panic("Incorrect expression '" + text + "': " + err.Error())
 	}
+	return Expr{Text: text}, end
+}
+
+// Returns the text of Go expression starting from provided position and the index
+// of the next char after it. Expression ends with ',', ')', '}' or ':'
+// outside of parentheses, brackets and quotes
+func scanExpression(chars []rune, pos, n int) (string, int) {
+	depth := 0
+	i := pos
+loop:
+	for i < n {
+		switch chars[i] {
+		case '"', '`':
+			_, _, i = getQuotedToken(chars, i, n)
+			continue
+		case '\\':
+			next := skipContinuation(chars, i, n)
+			if next == i {
+				panic("Unexpected '\\' in annotation attributes in '" + string(chars) + "'")
+			}
+			i = next
+			continue
+		case '(', '[':
+			depth++
+		case ')', ']':
+			if depth == 0 {
+				break loop
+			}
+			depth--
+		case ',', '}', ':', '{', '@':
+			if depth == 0 {
+				break loop
+			}
+		}
+		i++
+	}
+	return strings.TrimSpace(string(chars[pos:i])), i
+}
+
+// Returns the value of unquoted token.
+// References to constants or types (like LevelDebug, http.MethodGet or []string)
+// are returned as expressions, other tokens (numbers and booleans) are returned as literals
+func getUnquotedValue(t string) interface{} {
+	if isExpression(t) {
+		return Expr{Text: t}
+	}
+	return Literal(t)
+}
+
+// Returns true if unquoted token is an expression and not a number.
+// Boolean constants are not considered as expressions
+func isExpression(t string) bool {
+	if t == "" || t == "true" || t == "false" {
+		return false
+	}
+	return !isNumber(t, token.INT) && !isNumber(t, token.FLOAT)
 }
 
 func getToken(chars []rune, start, n int) (string, bool, int) {
-	quoted := false
-	escaped := false
 	var b []rune
 	for i := start; i < n; i++ {
 		switch chars[i] {
+		case '"', '`':
+			if i == start {
+				return getQuotedToken(chars, i, n)
+			}
+			// return token before quoted value
+			return string(b), false, i
 		case '\\':
-			if escaped {
-				escaped = false
-				b = append(b, '\\')
-			} else if quoted {
-				escaped = true
-			} else {
+			next := skipContinuation(chars, i, n)
+			if next == i {
 				panic("Unexpected '\\' in annotation attributes in '" + string(chars) + "'")
 			}
-		case '"':
-			if escaped {
-				escaped = false
-				b = append(b, '"')
-			} else if quoted {
-				// return unquoted value
-				return string(b), true, i + 1
-			} else if i == start {
-				quoted = true
-			} else {
-				// return token before quoted value
+			if i > start {
 				return string(b), false, i
 			}
+			// line continuation is a space
+			i, start = next-1, next
 		case ' ', '\t', '\r', '\n', '\f':
 			if i == start {
 				start++
-			} else if quoted {
-				b = append(b, chars[i])
-				escaped = false
 			} else {
 				return string(b), false, i
 			}
-		case ',', '=', '(', ')', '{', '}', '@':
+		case ',', '=', '(', ')', '{', '}', '@', ':':
 			if i == start {
 				return string(chars[i]), false, i + 1
-			} else if quoted {
-				b = append(b, chars[i])
-				escaped = false
-			} else {
-				return string(b), false, i
 			}
+			return string(b), false, i
 		default:
-			if escaped {
-				escaped = false
-			}
 			b = append(b, chars[i])
 		}
 	}
-	if quoted {
-		panic("Unclosed quote in '" + string(chars) + "'")
-	}
 	return string(b), false, n
 }
+
+// Returns unquoted value of the token which starts from the quote at provided position
+// and the index of next char after the token. Value in double quotes is interpreted
+// the same way as Go string literal, value in back quotes is a raw string
+func getQuotedToken(chars []rune, start, n int) (string, bool, int) {
+	quote := chars[start]
+	escaped := false
+	for i := start + 1; i < n; i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case chars[i] == '\\' && quote == '"':
+			escaped = true
+		case chars[i] == quote:
+			literal := string(chars[start : i+1])
+			value, err := strconv.Unquote(literal)
+			if err != nil {
+				panic("Incorrect quoted value " + literal + ": " + err.Error() +
+					"\nUse back quotes for raw strings like regular expressions")
+			}
+			return value, true, i + 1
+		}
+	}
+	panic("Unclosed quote in '" + string(chars) + "'")
+}
@@ -1,7 +1,19 @@
 package registry
 
 import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestFindAnnotations(t *testing.T) {
@@ -144,9 +156,9 @@ func TestFindAnnotations5(t *testing.T) {
 	if !found {
 		t.Fatal("Parameter 2 parameter value is not found. Expected name 'col1'")
 	}
-	sv11, ok := sv1.([]string)
+	sv11, ok := sv1.([]Literal)
 	if !ok {
-		t.Fatalf("Parameter 2 value is not []string. It is %#v", sv1)
+		t.Fatalf("Parameter 2 value is not []Literal. It is %#v", sv1)
 	}
 	if len(sv11) != 2 {
 		t.Fatalf("Parameter 2 value array has incorrect size %d. Expected is 2", len(sv11))
@@ -158,11 +170,1115 @@ func TestFindAnnotations5(t *testing.T) {
 	if !found {
 		t.Fatal("Parameter 2 parameter value is not found. Expected name 'col2'")
 	}
-	sv21, ok := sv2.(string)
+	sv21, ok := sv2.(Literal)
 	if !ok {
-		t.Fatalf("Parameter 2 value is not string. It is %#v", sv2)
+		t.Fatalf("Parameter 2 value is not Literal. It is %#v", sv2)
 	}
 	if sv21 != "2" {
 		t.Fatalf("Incorrect value of parameters of parameter 2: %#v", sv21)
 	}
 }
+
+func TestFindAnnotationsLenient(t *testing.T) {
+	LENIENT = true
+	defer func() { LENIENT = false }()
+	doc := "Contact author@example.com or ping @alice (see docs)\n@Entity(\"value\") @bob"
+	r := FindAnnotations(doc)
+	if len(r) != 2 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if r[0].Name != "Entity" {
+		t.Errorf("Annotation name is wrong. Expected %s but got %s", "Entity", r[0].Name)
+	}
+	if r[1].Name != "bob" {
+		t.Errorf("Annotation name is wrong. Expected %s but got %s", "bob", r[1].Name)
+	}
+}
+
+func TestFindAnnotationsLineStart(t *testing.T) {
+	LINE_START = true
+	defer func() { LINE_START = false }()
+	doc := "Text with @Ignored annotation\n  @Entity @Book(\"value\")\nand @Ignored"
+	r := FindAnnotations(doc)
+	if len(r) != 2 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if r[0].Name != "Entity" || r[1].Name != "Book" {
+		t.Errorf("Wrong annotations are found: %#v", r)
+	}
+}
+
+func TestFindQualifiedAnnotations(t *testing.T) {
+	doc := "@orm.Entity(Author=@r.Person(\"Mr.X\")) @My_Tag. See @Entity."
+	r := FindAnnotations(doc)
+	if len(r) != 3 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if r[0].Qualifier != "orm" || r[0].Name != "Entity" {
+		t.Errorf("Qualified name is wrong. Expected orm.Entity but got %s.%s", r[0].Qualifier, r[0].Name)
+	}
+	v, ok := r[0].Content["Author"].(AnnotationDoc)
+	if !ok {
+		t.Fatalf("Wrong parameter type. Expected AnnotationDoc but found %#v", r[0].Content["Author"])
+	}
+	if v.Qualifier != "r" || v.Name != "Person" {
+		t.Errorf("Qualified name is wrong. Expected r.Person but got %s.%s", v.Qualifier, v.Name)
+	}
+	if r[1].Qualifier != "" || r[1].Name != "My_Tag" {
+		t.Errorf("Annotation name is wrong. Expected My_Tag but got %s.%s", r[1].Qualifier, r[1].Name)
+	}
+	if r[2].Qualifier != "" || r[2].Name != "Entity" {
+		t.Errorf("Annotation name is wrong. Expected Entity but got %s.%s", r[2].Qualifier, r[2].Name)
+	}
+}
+
+func TestFindConstantReferences(t *testing.T) {
+	doc := "@Log(log.LevelDebug) @Route(Method=http.MethodGet, Methods={http.MethodGet, MethodCustom}, Code=200)"
+	r := FindAnnotations(doc)
+	if len(r) != 2 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	v, ok := r[0].Content[DEFAULT_PARAM].(Expr)
+	if !ok {
+		t.Fatalf("Wrong parameter type. Expected Expr but found %#v", r[0].Content[DEFAULT_PARAM])
+	}
+	if v.Text != "log.LevelDebug" {
+		t.Errorf("Expected expression is 'log.LevelDebug' but it is %s", v.Text)
+	}
+	v, ok = r[1].Content["Method"].(Expr)
+	if !ok || v.Text != "http.MethodGet" {
+		t.Errorf("Expected expression is 'http.MethodGet' but it is %#v", r[1].Content["Method"])
+	}
+	a, ok := r[1].Content["Methods"].([]Expr)
+	if !ok {
+		t.Fatalf("Wrong parameter type. Expected []Expr but found %#v", r[1].Content["Methods"])
+	}
+	if len(a) != 2 || a[0].Text != "http.MethodGet" || a[1].Text != "MethodCustom" {
+		t.Errorf("Incorrect array of expressions: %#v", a)
+	}
+	if r[1].Content["Code"] != Literal("200") {
+		t.Errorf("Expected parameter value is '200' but it is %#v", r[1].Content["Code"])
+	}
+}
+
+func TestFindConstantExpressions(t *testing.T) {
+	doc := `@Cache(60 * 60) @Limit(Max=1<<20, Min=-5, Sizes={KB * 2, (1 + 2) * 3}) @Doc(Text="part one, " + "part two")`
+	r := FindAnnotations(doc)
+	if len(r) != 3 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	v, ok := r[0].Content[DEFAULT_PARAM].(Expr)
+	if !ok || v.Text != "60 * 60" {
+		t.Errorf("Expected expression is '60 * 60' but it is %#v", r[0].Content[DEFAULT_PARAM])
+	}
+	v, ok = r[1].Content["Max"].(Expr)
+	if !ok || v.Text != "1<<20" {
+		t.Errorf("Expected expression is '1<<20' but it is %#v", r[1].Content["Max"])
+	}
+	if r[1].Content["Min"] != Literal("-5") {
+		t.Errorf("Expected parameter value is '-5' but it is %#v", r[1].Content["Min"])
+	}
+	a, ok := r[1].Content["Sizes"].([]Expr)
+	if !ok || len(a) != 2 || a[0].Text != "KB * 2" || a[1].Text != "(1 + 2) * 3" {
+		t.Errorf("Incorrect array of expressions: %#v", r[1].Content["Sizes"])
+	}
+	v, ok = r[2].Content["Text"].(Expr)
+	if !ok || v.Text != `"part one, " + "part two"` {
+		t.Errorf("Incorrect string expression: %#v", r[2].Content["Text"])
+	}
+}
+
+func TestFindTypeReferences(t *testing.T) {
+	doc := "@Mapper(Target=User, Sources={*pkg.Order, []string})"
+	r := FindAnnotations(doc)
+	if len(r) != 1 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	v, ok := r[0].Content["Target"].(Expr)
+	if !ok || v.Text != "User" {
+		t.Errorf("Expected expression is 'User' but it is %#v", r[0].Content["Target"])
+	}
+	a, ok := r[0].Content["Sources"].([]Expr)
+	if !ok {
+		t.Fatalf("Wrong parameter type. Expected []Expr but found %#v", r[0].Content["Sources"])
+	}
+	if len(a) != 2 || a[0].Text != "*pkg.Order" || a[1].Text != "[]string" {
+		t.Errorf("Incorrect array of expressions: %#v", a)
+	}
+}
+
+func TestFindMapParameters(t *testing.T) {
+	doc := "@Metric(Labels={\"env\": \"prod\", tier: {1, 2}}, Owners={1: @Person(\"x\")})"
+	r := FindAnnotations(doc)
+	if len(r) != 1 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	m, ok := r[0].Content["Labels"].([]MapEntry)
+	if !ok {
+		t.Fatalf("Wrong parameter type. Expected []MapEntry but found %#v", r[0].Content["Labels"])
+	}
+	if len(m) != 2 {
+		t.Fatalf("Incorrect map size. Expected 2 but it is %d", len(m))
+	}
+	if m[0].Key != "env" || m[0].Value != "prod" {
+		t.Errorf("Incorrect first map entry: %#v", m[0])
+	}
+	if k, ok := m[1].Key.(Expr); !ok || k.Text != "tier" {
+		t.Errorf("Incorrect second map key: %#v", m[1].Key)
+	}
+	if v, ok := m[1].Value.([]Literal); !ok || len(v) != 2 {
+		t.Errorf("Incorrect second map value: %#v", m[1].Value)
+	}
+	m, ok = r[0].Content["Owners"].([]MapEntry)
+	if !ok || len(m) != 1 {
+		t.Fatalf("Wrong parameter value. Expected map with 1 entry but found %#v", r[0].Content["Owners"])
+	}
+	if v, ok := m[0].Value.(AnnotationDoc); !ok || v.Name != "Person" || m[0].Key != Literal("1") {
+		t.Errorf("Incorrect map entry: %#v", m[0])
+	}
+}
+
+func TestFindNestedArrays(t *testing.T) {
+	doc := "@Index(Columns={{\"a\",\"b\"},{\"c\"}}, Mixed={\"a\", Col, @Person})"
+	r := FindAnnotations(doc)
+	if len(r) != 1 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	v, ok := r[0].Content["Columns"].([]interface{})
+	if !ok {
+		t.Fatalf("Wrong parameter type. Expected []interface{} but found %#v", r[0].Content["Columns"])
+	}
+	if len(v) != 2 {
+		t.Fatalf("Incorrect value array size. Expected 2 but it is %d", len(v))
+	}
+	if s, ok := v[0].([]string); !ok || len(s) != 2 || s[0] != "a" || s[1] != "b" {
+		t.Errorf("Incorrect first nested array: %#v", v[0])
+	}
+	if s, ok := v[1].([]string); !ok || len(s) != 1 || s[0] != "c" {
+		t.Errorf("Incorrect second nested array: %#v", v[1])
+	}
+	v, ok = r[0].Content["Mixed"].([]interface{})
+	if !ok || len(v) != 3 {
+		t.Fatalf("Wrong parameter value. Expected array of 3 values but found %#v", r[0].Content["Mixed"])
+	}
+	if v[0] != "a" {
+		t.Errorf("Expected first value is 'a' but it is %#v", v[0])
+	}
+	if e, ok := v[1].(Expr); !ok || e.Text != "Col" {
+		t.Errorf("Expected second value is expression 'Col' but it is %#v", v[1])
+	}
+	if a, ok := v[2].(AnnotationDoc); !ok || a.Name != "Person" {
+		t.Errorf("Expected third value is annotation 'Person' but it is %#v", v[2])
+	}
+}
+
+func TestFindEmptyArrays(t *testing.T) {
+	r := FindAnnotations("@Index(Columns={}, Nested={{\"a\"},{}}, Labels={ })")
+	if len(r) != 1 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if v, ok := r[0].Content["Columns"].([]interface{}); !ok || len(v) != 0 {
+		t.Errorf("Expected empty array but found %#v", r[0].Content["Columns"])
+	}
+	v, ok := r[0].Content["Nested"].([]interface{})
+	if !ok || len(v) != 2 {
+		t.Fatalf("Wrong parameter value. Expected array of 2 values but found %#v", r[0].Content["Nested"])
+	}
+	if e, ok := v[1].([]interface{}); !ok || len(e) != 0 {
+		t.Errorf("Expected empty nested array but found %#v", v[1])
+	}
+	defer setupTestRoot(t, map[string]string{
+		"test/empty/ann.go": "package empty\n\ntype Index struct {\n\tColumns []string\n\tNested [][]string\n\tLabels map[string]string\n}\n",
+	})()
+	expected := "Index{\n    []string{\n    },\n    [][]string{\n        []string{\n            \"a\",\n        },\n" +
+		"        []string{\n        },\n    },\n    map[string]string{\n    },\n}"
+	if s := generateCode(r[0], "test/empty"); s != expected {
+		t.Errorf("Incorrect empty arrays: %s", s)
+	}
+}
+
+func TestFindEscapedValues(t *testing.T) {
+	doc := "@Format(Text=\"tab\\there\\n\\u00e9\\x41\\101 \\\"q\\\"\", Pattern=`^\\d+\\n$`)"
+	r := FindAnnotations(doc)
+	if len(r) != 1 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if v := r[0].Content["Text"]; v != "tab\there\néAA \"q\"" {
+		t.Errorf("Incorrect unquoted value: %#v", v)
+	}
+	if v := r[0].Content["Pattern"]; v != "^\\d+\\n$" {
+		t.Errorf("Incorrect raw string value: %#v", v)
+	}
+}
+
+func TestFindIncorrectEscape(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Incorrect escape sequence is not reported")
+		}
+	}()
+	FindAnnotations("@Pattern(\"^\\d+$\")")
+}
+
+func TestFindMultilineAnnotations(t *testing.T) {
+	doc := "Service description\n" +
+		"@Route(Method=\"GET\",\n    Path=\"/users\")\n" +
+		"Example:\n\n\t@Ignored(\"code\")\n    @Ignored\n\n" +
+		"Mail to admin@@example.com\n" +
+		"@Doc \\\n  (\"continued\")\n" +
+		"@Plain\n(not parameters)"
+	r := FindAnnotations(doc)
+	if len(r) != 3 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if r[0].Name != "Route" || r[0].Content["Method"] != "GET" || r[0].Content["Path"] != "/users" {
+		t.Errorf("Incorrect multi-line annotation: %#v", r[0])
+	}
+	if r[1].Name != "Doc" || r[1].Content[DEFAULT_PARAM] != "continued" {
+		t.Errorf("Incorrect continued annotation: %#v", r[1])
+	}
+	if r[2].Name != "Plain" || len(r[2].Content) != 0 {
+		t.Errorf("Parameters on the next line should not be parsed: %#v", r[2])
+	}
+}
+
+func TestFindDecoratedAnnotations(t *testing.T) {
+	LINE_START = true
+	defer func() { LINE_START = false }()
+	doc := "*\n * Entities:\n *   - @Entity(\n *       name=\"x\")\n *   1. @Book\n"
+	r := FindAnnotations(doc)
+	if len(r) != 2 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if r[0].Name != "Entity" || r[0].Content["name"] != "x" || r[1].Name != "Book" {
+		t.Errorf("Wrong annotations are found: %#v", r)
+	}
+}
+
+func TestFindTagAnnotations(t *testing.T) {
+	src := "package p\ntype A struct {\n\tName string `json:\"name\" ann:\"@Column(Name=\\\"name\\\") @NotNull\"`\n}"
+	f, err := parser.ParseFile(token.NewFileSet(), "p.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := f.Decls[0].(*ast.GenDecl).Specs[0].(*ast.TypeSpec).Type.(*ast.StructType).Fields.List[0]
+	r := FindAnnotations(getTagAnnotations(field))
+	if len(r) != 2 {
+		t.Fatalf("Incorrect amount of entities: %d", len(r))
+	}
+	if r[0].Name != "Column" || r[0].Content["Name"] != "name" || r[1].Name != "NotNull" {
+		t.Errorf("Wrong annotations are found: %#v", r)
+	}
+}
+
+func TestParseAnnotationsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "annotations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	content := "import (\n\t\"sort\"\n)\n\n// comment\nerrors.New @Doc(\"new\")\nsort.Interface.Len\n    @Doc(\n        Text=\"len\")\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "ext.ann"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	r, imports := ParseAnnotationsFile(dir, "ext.ann", "p")
+	if len(imports) != 1 || imports[0] != "sort" {
+		t.Errorf("Incorrect imports: %v", imports)
+	}
+	if len(r) != 2 {
+		t.Fatalf("Incorrect amount of entries: %d", len(r))
+	}
+	if r[0].Type != "func" || r[0].FullPackage != "errors" || r[0].Name != "New" || len(r[0].Self) != 1 {
+		t.Errorf("Incorrect func entry: %#v", r[0])
+	}
+	m := r[1].Methods["Len"]
+	if r[1].Type != "interface" || r[1].Name != "Interface" || len(m) != 1 || m[0].Content["Text"] != "len" {
+		t.Errorf("Incorrect method entry: %#v", r[1])
+	}
+}
+
+func TestApplyDefaults(t *testing.T) {
+	src := "package p\n\n//annotations:defaults @Owner(\"payments\") \\\n//   @Tag(\"x\")\n" +
+		"//annotations:defaults struct,field merge @Table(Schema=\"billing\")\n"
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p.go", src, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defaults := findDefaults(f.Comments, fset)
+	if len(defaults) != 3 {
+		t.Fatalf("Incorrect amount of defaults: %d", len(defaults))
+	}
+	explicit := FindAnnotations("@Owner(\"sales\") @Table(Name=\"orders\")")
+	r := applyDefaults(explicit, defaults, "struct", nil)
+	if len(r) != 3 {
+		t.Fatalf("Incorrect amount of annotations: %d", len(r))
+	}
+	if r[0].Name != "Owner" || r[0].Content[DEFAULT_PARAM] != "sales" {
+		t.Errorf("Explicit annotation should override default one: %#v", r[0])
+	}
+	if r[1].Name != "Table" || r[1].Content["Name"] != "orders" || r[1].Content["Schema"] != "billing" {
+		t.Errorf("Explicit annotation should be merged with default one: %#v", r[1])
+	}
+	if r[2].Name != "Tag" {
+		t.Errorf("Default annotation is not added: %#v", r[2])
+	}
+	r = applyDefaults(nil, defaults, "method", nil)
+	if len(r) != 0 {
+		t.Errorf("Defaults are applied to wrong kind of target: %#v", r)
+	}
+}
+
+func TestMergeDefaults(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/orm/ann.go": "package orm\n\ntype Table struct {\n\tName string `annotation:\"Name,table,value\"`\n\tSchema string\n}\n",
+	})()
+	defaults := parseDefaults("merge @Table(Name=\"x\", Schema=\"billing\")", "a.go:1:1")
+	merge := func(doc string) AnnotationDoc {
+		r := applyDefaults(FindAnnotations(doc), defaults, "struct", []string{"test/orm"})
+		if len(r) != 1 {
+			t.Fatalf("Incorrect amount of annotations: %d", len(r))
+		}
+		return r[0]
+	}
+	if r := merge("@Table(\"orders\")"); len(r.Content) != 2 || r.Content[DEFAULT_PARAM] != "orders" || r.Content["Schema"] != "billing" {
+		t.Errorf("Value without name should override default attribute: %#v", r.Content)
+	}
+	a := merge("@Table(table=\"orders\")")
+	if len(a.Content) != 2 || a.Content["table"] != "orders" || a.Content["Schema"] != "billing" {
+		t.Errorf("Attribute alias should override default attribute: %#v", a.Content)
+	}
+	if r := generateCode(a, "test/orm"); r != "Table{\n    \"orders\",\n    \"billing\",\n}" {
+		t.Errorf("Incorrect merged annotation: %s", r)
+	}
+	IGNORE_CASE = true
+	defer func() { IGNORE_CASE = false }()
+	if r := merge("@Table(name=\"orders\", schema=\"sales\")"); len(r.Content) != 2 || r.Content["name"] != "orders" || r.Content["schema"] != "sales" {
+		t.Errorf("Attributes in other case should override default attributes: %#v", r.Content)
+	}
+}
+
+func TestGetBuildPackage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "annotations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if bp := getBuildPackage(dir); bp.Dir != dir || len(bp.GoFiles) != 0 {
+		t.Errorf("Incorrect package without sources: %#v", bp)
+	}
+	files := map[string]string{
+		"a.go":             "package p\n",
+		"a_test.go":        "package p_test\n",
+		"ignored.go":       "//go:build ignore\n\npackage main\n",
+		"pro.go":           "//go:build pro\n\npackage p\n",
+		"p_annotations.go": GENERATED_HEADER + "\n\npackage p\n",
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bp := getBuildPackage(dir)
+	if len(bp.GoFiles) != 2 || bp.GoFiles[0] != "a.go" || bp.GoFiles[1] != "p_annotations.go" {
+		t.Errorf("Incorrect source files: %v", bp.GoFiles)
+	}
+	if len(bp.XTestGoFiles) != 1 || bp.XTestGoFiles[0] != "a_test.go" {
+		t.Errorf("Incorrect test files: %v", bp.XTestGoFiles)
+	}
+	// generated registry is skipped whatever output name is given
+	if r := selectSources(dir, bp.GoFiles, "registry.go"); len(r) != 1 || r[0] != "a.go" {
+		t.Errorf("Incorrect selected sources: %v", r)
+	}
+	BUILD_TAGS = "dev,pro"
+	defer func() { BUILD_TAGS = "" }()
+	bp = getBuildPackage(dir)
+	if r := selectSources(dir, bp.GoFiles, "p_annotations.go"); len(r) != 2 || r[0] != "a.go" || r[1] != "pro.go" {
+		t.Errorf("Incorrect selected sources with build tags: %v", r)
+	}
+	if !isGeneratedRegistry(filepath.Join(dir, "p_annotations.go")) || isGeneratedRegistry(filepath.Join(dir, "a.go")) {
+		t.Error("Generated registry is not recognized")
+	}
+}
+
+func TestCombineMethodsAndFields(t *testing.T) {
+	source := AnnotatedEntry{"struct", "test/zo", "Order", AnnotationsData{
+		Self:   []AnnotationDoc{{Name: "Entity"}},
+		Fields: map[string][]AnnotationDoc{"Total": {{Name: "NotNull"}}, "Id": nil},
+	}}
+	external := AnnotatedEntry{"struct", "test/zo", "Order", AnnotationsData{
+		Fields: map[string][]AnnotationDoc{"Total": {{Name: "Column"}}},
+	}}
+	r := combineMethodsAndFields([]AnnotatedEntry{source, external})
+	if len(r) != 1 || len(r[0].Self) != 1 || len(r[0].Fields) != 2 {
+		t.Fatalf("Incorrect combined entries: %#v", r)
+	}
+	if total := r[0].Fields["Total"]; len(total) != 2 || total[0].Name != "NotNull" || total[1].Name != "Column" {
+		t.Errorf("Annotations of the field are not combined: %#v", total)
+	}
+}
+
+func TestMapCombinesAnnotations(t *testing.T) {
+	Map("test/p.A", Annotations{Self: []interface{}{"a"}, Fields: map[string][]interface{}{"F": {"f"}}})
+	Map("test/p.A", Annotations{Self: []interface{}{"b"}, Fields: map[string][]interface{}{"F": {"g"}, "M": {"m"}}})
+	defer delete(typeRegistry, "test/p.A")
+	if a := GetStructAnnotations("test/p.A"); len(a) != 2 || a[0] != "a" || a[1] != "b" {
+		t.Errorf("Incorrect combined annotations: %#v", a)
+	}
+	if a := GetFieldAnnotations("test/p.A", "F"); len(a) != 2 {
+		t.Errorf("Incorrect combined field annotations: %#v", a)
+	}
+	if a := GetFieldAnnotations("test/p.A", "M"); len(a) != 1 {
+		t.Errorf("Incorrect combined field annotations: %#v", a)
+	}
+}
+
+// Creates temporary GOPATH root with provided sources and makes it the only root.
+// Returns the function restoring original roots
+func setupTestRoot(t *testing.T, files map[string]string) func() {
+	dir, err := ioutil.TempDir("", "annotations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		os.MkdirAll(filepath.Join(dir, "src", filepath.Dir(name)), 0755)
+		if err := ioutil.WriteFile(filepath.Join(dir, "src", name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	roots := ROOTS
+	ROOTS = []string{dir}
+	return func() {
+		ROOTS = roots
+		os.RemoveAll(dir)
+	}
+}
+
+// Returns the message of panic caused by generation of the annotation
+func generateError(a AnnotationDoc, pck string) string {
+	return panicMessage(func() { generateCode(a, pck) })
+}
+
+// Returns the message of panic caused by provided function
+func panicMessage(f func()) (message string) {
+	defer func() {
+		if r := recover(); r != nil {
+			message = fmt.Sprint(r)
+		}
+	}()
+	f()
+	return ""
+}
+
+// Returns the code generated for the annotation in the package of its struct
+func generateCode(a AnnotationDoc, pck string) string {
+	return generateStruct(&a, pck, nil, newImportAliases(pck), "")
+}
+
+func TestGenerateAliasedAnnotations(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/base/base.go": "package base\n\ntype Base struct {\n\tName string\n\tEnabled bool\n\tLimit int `default:\"5\"`\n}\n",
+		"test/ann/ann.go":   "package ann\n\nimport \"test/base\"\n\ntype (\n\tAlias = base.Base\n\tDerived base.Base\n)\n",
+	})()
+	a := AnnotationDoc{Name: "Alias", Content: map[string]interface{}{"Name": "a", "Enabled": Literal("true")}}
+	if r := generateCode(a, "test/ann"); r != "Alias{\n    \"a\",\n    true,\n    5,\n}" {
+		t.Errorf("Incorrect aliased annotation: %s", r)
+	}
+	d := AnnotationDoc{Name: "Derived", Content: map[string]interface{}{"Name": "d"}}
+	if r := generateCode(d, "test/ann"); r != "Derived{\n    \"d\",\n    false,\n    5,\n}" {
+		t.Errorf("Incorrect derived annotation: %s", r)
+	}
+}
+
+func TestGenerateRegistryImports(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/pat/ann/ann.go": "package ann\n\nimport (\n\t\"reflect\"\n\t\"time\"\n)\n\ntype Pattern struct {\n\tText string\n" +
+			"\tSince time.Time `default:\"2024-01-02T03:04:05Z\"`\n\tKind reflect.Type\n}\n",
+		"test/pat/a.go": "package pat\n\ntype Local int\n",
+	})()
+	text := "call time.Now or reflect.TypeOf of test/pat/ann.Pattern"
+	a := AnnotationDoc{Name: "Pattern", Qualifier: "ann", Package: "test/pat/ann",
+		Content: map[string]interface{}{"Text": text, "Kind": Expr{Text: "Local", Package: "test/pat"}}}
+	r := generateRegistry([]AnnotatedEntry{{"struct", "test/pat", "Handler", AnnotationsData{Self: []AnnotationDoc{a}}}}, "test/pat", nil)
+	expected := []string{
+		"import a1 \"test/pat/ann\"\nimport a2 \"time\"\nimport a3 \"reflect\"\n",
+		"a1.Pattern{\n                    " + strconv.Quote(text) + ",\n",
+		"*_base.DecodeText(new(a2.Time), \"2024-01-02T03:04:05Z\").(*a2.Time),\n",
+		"a3.TypeOf((*Local)(nil)).Elem(),\n",
+	}
+	for _, code := range expected {
+		if !strings.Contains(r, code) {
+			t.Errorf("Generated registry doesn't contain %s:\n%s", code, r)
+		}
+	}
+}
+
+func TestGenerateStableRegistry(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/st/a/a.go": "package a\n\ntype Column struct{}\n",
+		"test/st/b/b.go": "package b\n\ntype Index struct{}\n",
+		"test/st/st.go": "package st\n\ntype (\n\tUser struct{ Name string }\n\tOrder struct{ Id, Name, Total int }\n)\n\n" +
+			"func (Order) Pay()    {}\nfunc (Order) Cancel() {}\n",
+	})()
+	column := AnnotationDoc{Name: "Column", Qualifier: "a", Package: "test/st/a"}
+	index := AnnotationDoc{Name: "Index", Qualifier: "b", Package: "test/st/b"}
+	generate := func() string {
+		entries := combineMethodsAndFields([]AnnotatedEntry{
+			{"struct", "test/st", "User", AnnotationsData{Fields: map[string][]AnnotationDoc{"Name": {index}}}},
+			{"struct", "test/st", "Order", AnnotationsData{
+				Fields:  map[string][]AnnotationDoc{"Total": {index}, "Id": {column}, "Name": {index}},
+				Methods: map[string][]AnnotationDoc{"Pay": {column}, "Cancel": {index}},
+			}},
+		})
+		return generateRegistry(entries, "test/st", nil)
+	}
+	r := generate()
+	for i := 0; i < 10; i++ {
+		if next := generate(); next != r {
+			t.Fatalf("Generated registry is changed between runs:\n%s\n%s", r, next)
+		}
+	}
+	if !strings.Contains(r, "import a1 \"test/st/a\"\nimport a2 \"test/st/b\"\n") {
+		t.Errorf("Incorrect imports of generated registry:\n%s", r)
+	}
+	order := []string{"\"test/st.Order\"", "\"Id\"", "\"Name\"", "\"Total\"", "\"Cancel\"", "\"Pay\"", "\"test/st.User\""}
+	for i := 1; i < len(order); i++ {
+		if strings.Index(r, order[i-1]) > strings.Index(r, order[i]) {
+			t.Errorf("%s is generated after %s:\n%s", order[i-1], order[i], r)
+		}
+	}
+}
+
+func TestGenerateTestRegistries(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/suite/ann/ann.go": "package ann\n\nimport \"reflect\"\n\ntype (\n\tMapper struct {\n\tTarget reflect.Type\n\t}\n" +
+			"\tPattern struct {\n\tValue string\n\t}\n\tOnError struct {\n\tHandler func(error)\n\t}\n)\n",
+		"test/suite/app.go": "package suite\n",
+		"test/suite/app_test.go": "package suite\n\nimport \"test/suite/ann\"\n\ntype Fixture struct{}\n\nconst testLocal = \"local\"\n\n" +
+			"type (\n\t// @ann.Mapper(Target=Fixture)\n\t// @ann.Pattern(testLocal)\n\tSuite struct{}\n)\n",
+		"test/suite/x_test.go": "package suite_test\n\nimport \"test/suite/ann\"\n\nfunc h(error) {}\n\n" +
+			"type (\n\t// @ann.OnError(Handler=h)\n\tErrors struct{}\n)\n",
+	})()
+	dir := filepath.Join(ROOTS[0], "src", "test", "suite")
+	GenerateRegistry(dir, "suite", "")
+	if _, err := os.Stat(filepath.Join(dir, "suite_annotations.go")); !os.IsNotExist(err) {
+		t.Errorf("Registry without annotations is generated: %v", err)
+	}
+	content, err := ioutil.ReadFile(filepath.Join(dir, "suite_annotations_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"package suite\n", "TypeOf((*Fixture)(nil)).Elem(),\n", "                    testLocal,\n"} {
+		if !strings.Contains(string(content), code) {
+			t.Errorf("Registry of tests doesn't contain %q:\n%s", code, content)
+		}
+	}
+	content, err = ioutil.ReadFile(filepath.Join(dir, "suite_annotations_x_test.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, code := range []string{"package suite_test\n", "_base.Map(\"test/suite_test.Errors\"", "                    h,\n"} {
+		if !strings.Contains(string(content), code) {
+			t.Errorf("Registry of external tests doesn't contain %q:\n%s", code, content)
+		}
+	}
+}
+
+func TestGenerateUnknownAttributes(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/attrs/ann.go": "package attrs\n\ntype (\n\tEntity struct {\n\tName string\n\tTables []string\n\t}\n\tId struct{}\n" +
+			"\tFlags struct {\n\tV int\n\tEnabled bool\n\t}\n)\n",
+	})()
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{"name": "a"}}, "test/attrs"); r != "Annotation '@Entity' has no attribute 'name', did you mean 'Name'?" {
+		t.Errorf("Incorrect error for attribute in wrong case: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{"Nmae": "a"}}, "test/attrs"); r != "Annotation '@Entity' has no attribute 'Nmae', did you mean 'Name'?" {
+		t.Errorf("Incorrect error for misspelled attribute: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{"Schema": "a"}}, "test/attrs"); r != "Annotation '@Entity' has no attribute 'Schema'" {
+		t.Errorf("Incorrect error for unknown attribute: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{DEFAULT_PARAM: "a"}}, "test/attrs"); r != "Annotation '@Entity' has 2 attributes, the name of attribute should be specified for its value"+
+		" or the field for it should be marked by the tag `annotation:\",value\"`" {
+		t.Errorf("Incorrect error for unnamed value: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{"Name": AnnotationDoc{Name: "Id"}}}, "test/attrs"); r != "Attribute 'Name' of annotation '@Entity': Annotation '@Id' can't be assigned to the value of type string" {
+		t.Errorf("Incorrect error for annotation value: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{"Name": []string{"a"}}}, "test/attrs"); r != "Attribute 'Name' of annotation '@Entity': Array of values can't be assigned to the value of type string" {
+		t.Errorf("Incorrect error for array value: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Entity", Content: map[string]interface{}{"Tables": "a"}}, "test/attrs"); r != "Attribute 'Tables' of annotation '@Entity': String \"a\" can't be assigned to the value of type []string" {
+		t.Errorf("Incorrect error for scalar value: %s", r)
+	}
+	if r := generateError(FindAnnotations(`@Flags(V="7")`)[0], "test/attrs"); r != "Attribute 'V' of annotation '@Flags': String \"7\" can't be assigned to the value of type int" {
+		t.Errorf("Incorrect error for quoted number: %s", r)
+	}
+	if r := generateError(FindAnnotations(`@Flags(Enabled="true")`)[0], "test/attrs"); r != "Attribute 'Enabled' of annotation '@Flags': String \"true\" can't be assigned to the value of type bool" {
+		t.Errorf("Incorrect error for quoted boolean: %s", r)
+	}
+	if r := generateError(FindAnnotations(`@Entity(Name=5)`)[0], "test/attrs"); r != "Attribute 'Name' of annotation '@Entity': Value '5' can't be assigned to the value of type string" {
+		t.Errorf("Incorrect error for unquoted number: %s", r)
+	}
+	if r := generateCode(FindAnnotations(`@Flags(V=7, Enabled=true)`)[0], "test/attrs"); r != "Flags{\n    7,\n    true,\n}" {
+		t.Errorf("Incorrect annotation with unquoted values: %s", r)
+	}
+}
+
+func TestGenerateRequiredAttributes(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/req/ann.go": "package req\n\ntype Route struct {\n\tPath string `required:\"true\"`\n\tMethod string `required:\"true\"`\n\tName string\n}\n",
+		"test/req/a.go": "package req\n\ntype (\n\t// Index page\n\t//go:generate echo\n\t// @Route(\n\t//     Name=\"index\")\n" +
+			"\t/* @Route(Path=\"/\",\n\t   Method=\"GET\") */ // @Route(Path=\"/index\")\n\tIndex struct{}\n)\n",
+	})()
+	dir := filepath.Join(ROOTS[0], "src", "test", "req")
+	entries, _, _ := ParseFile(dir, "a.go")
+	if len(entries) != 1 || len(entries[0].Self) != 3 {
+		t.Fatalf("Incorrect annotations: %#v", entries)
+	}
+	for i, line := range []string{":6", ":8", ":9"} {
+		if p := entries[0].Self[i].Position; p != filepath.Join(dir, "a.go")+line {
+			t.Errorf("Incorrect position of annotation %d: %s", i, p)
+		}
+	}
+	if r := generateError(entries[0].Self[0], "test/req"); r != filepath.Join(dir, "a.go")+":6: Required attributes 'Path', 'Method' of annotation '@Route' are not specified" {
+		t.Errorf("Incorrect error for missing attributes: %s", r)
+	}
+	a := AnnotationDoc{Name: "Route", Content: map[string]interface{}{"Path": "/", "Method": "GET"}}
+	if r := generateError(a, "test/req"); r != "" {
+		t.Errorf("Unexpected error for required attributes: %s", r)
+	}
+}
+
+func TestGenerateAttributeNames(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/names/ann.go": "package names\n\ntype (\n\tBook struct {\n\tName string `annotation:\"name,title\"`\n\tPages int\n\t}\n\tPage struct {\n\tNumber int\n\tNum int `annotation:\"number\"`\n\t}\n)\n",
+	})()
+	a := AnnotationDoc{Name: "Book", Content: map[string]interface{}{"title": "a", "Pages": Literal("1")}}
+	if r := generateCode(a, "test/names"); r != "Book{\n    \"a\",\n    1,\n}" {
+		t.Errorf("Incorrect annotation with alias: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Book", Content: map[string]interface{}{"Name": "a"}}, "test/names"); r != "Annotation '@Book' has no attribute 'Name', did you mean 'name'?" {
+		t.Errorf("Incorrect error for field name: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Book", Content: map[string]interface{}{"name": "a", "title": "b"}}, "test/names"); r != "Attribute 'name' of annotation '@Book' is specified twice as 'name' and 'title'" {
+		t.Errorf("Incorrect error for duplicate attribute: %s", r)
+	}
+	IGNORE_CASE = true
+	defer func() { IGNORE_CASE = false }()
+	a = AnnotationDoc{Name: "Book", Content: map[string]interface{}{"Title": "a", "pages": Literal("1")}}
+	if r := generateCode(a, "test/names"); r != "Book{\n    \"a\",\n    1,\n}" {
+		t.Errorf("Incorrect annotation in case-insensitive mode: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Page", Content: map[string]interface{}{"number": Literal("1")}}, "test/names"); r != "Attribute 'number' of annotation '@Page' is defined by fields 'Number' and 'Num'" {
+		t.Errorf("Incorrect error for colliding attributes: %s", r)
+	}
+}
+
+func TestFindMixedParameters(t *testing.T) {
+	r := FindAnnotations(`@Route("/users", Method="POST", Secure=true)`)
+	if len(r) != 1 || len(r[0].Content) != 3 || r[0].Content[DEFAULT_PARAM] != "/users" ||
+		r[0].Content["Method"] != "POST" || r[0].Content["Secure"] != Literal("true") {
+		t.Fatalf("Incorrect mixed parameters: %#v", r)
+	}
+	defer setupTestRoot(t, map[string]string{
+		"test/route/ann.go": "package route\n\ntype Route struct {\n\tMethod string\n\tPath string `annotation:\"path,value\"`\n}\n",
+	})()
+	a := AnnotationDoc{Name: "Route", Content: map[string]interface{}{DEFAULT_PARAM: "/users", "Method": "POST"}}
+	if r := generateCode(a, "test/route"); r != "Route{\n    \"POST\",\n    \"/users\",\n}" {
+		t.Errorf("Incorrect annotation with value field: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Route", Content: map[string]interface{}{DEFAULT_PARAM: "/", "path": "/"}}, "test/route"); r != "Attribute 'path' of annotation '@Route' is specified twice as 'value without name' and 'path'" {
+		t.Errorf("Incorrect error for duplicate value: %s", r)
+	}
+}
+
+func TestGenerateDefaultValues(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/defs/ann.go": "package defs\n\ntype (\n\tPerson struct {\n\tName string\n\t}\n" +
+			"\tBook struct {\n\tTags []string `default:\"{\\\"a\\\", \\\"b\\\"}\"`\n" +
+			"\tAuthor *Person `default:\"@Person(\\\"anon\\\")\"`\n" +
+			"\tPages *int `default:\"5\"`\n" +
+			"\tSizes []int `default:\"{Small, Small * 2}\"`\n" +
+			"\tLevels []int `default:\"{small, 2}\"`\n" +
+			"\tTitle string `default:\" \"`\n\t}\n)\n\nconst (\n\tSmall = 10\n\tsmall = 3\n)\n",
+	})()
+	a := AnnotationDoc{Name: "Book", Content: map[string]interface{}{}}
+	r := generateCode(a, "test/defs")
+	expected := "Book{\n    []string{\n        \"a\",\n        \"b\",\n    },\n" +
+		"    &Person{\n        \"anon\",\n    },\n" +
+		"    &[]int{5}[0],\n" +
+		"    []int{\n        Small,\n        20,\n    },\n" +
+		"    []int{\n        small,\n        2,\n    },\n" +
+		"    \" \",\n}"
+	if r != expected {
+		t.Errorf("Incorrect default values: %s", r)
+	}
+	// unexported constant is not accessible from the registry of another package
+	r = generateStruct(&a, "test/defs", nil, newImportAliases("test/app"), "")
+	expected = "a1.Book{\n    []string{\n        \"a\",\n        \"b\",\n    },\n" +
+		"    &a1.Person{\n        \"anon\",\n    },\n" +
+		"    &[]int{5}[0],\n" +
+		"    []int{\n        a1.Small,\n        20,\n    },\n" +
+		"    []int{\n        3,\n        2,\n    },\n" +
+		"    \" \",\n}"
+	if r != expected {
+		t.Errorf("Incorrect default values in another package: %s", r)
+	}
+}
+
+func TestGenerateDecodedValues(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/timing/ann.go": "package timing\n\nimport \"time\"\n\ntype (\n\tLevel int\n\tTiming struct {\n" +
+			"\tTimeout time.Duration\n\tRetry *time.Duration `default:\"1m30s\"`\n\tSince time.Time\n" +
+			"\tEnabled bool `default:\"true\"`\n\tLevel Level\n\t}\n)\n",
+	})()
+	a := AnnotationDoc{Name: "Timing", Content: map[string]interface{}{"Timeout": "5s", "Since": "2024-01-02T03:04:05Z", "Level": Literal("2")}}
+	r := generateCode(a, "test/timing")
+	expected := "Timing{\n    5000000000,\n    &[]a1.Duration{90000000000}[0],\n" +
+		"    *_base.DecodeText(new(a1.Time), \"2024-01-02T03:04:05Z\").(*a1.Time),\n    true,\n    2,\n}"
+	if r != expected {
+		t.Errorf("Incorrect decoded values: %s", r)
+	}
+	if r := generateError(AnnotationDoc{Name: "Timing", Content: map[string]interface{}{"Since": "yesterday"}}, "test/timing"); !strings.HasPrefix(r, "Attribute 'Since' of annotation '@Timing': Incorrect time 'yesterday'") {
+		t.Errorf("Incorrect error for time value: %s", r)
+	}
+	var since time.Time
+	if DecodeText(&since, "2024-01-02T03:04:05Z"); since.Year() != 2024 {
+		t.Errorf("Incorrect decoded time: %v", since)
+	}
+}
+
+func TestCheckTarget(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/meta/ann.go": "package meta\n\nimport _ \"github.com/SphereSoftware/go-annotations/registry\"\n\n" +
+			"// Column of the table\n// @registry.Target({\"field\", \"method\"})\ntype Column struct {\n\tName string\n}\n\n" +
+			"// @see docs\ntype Table struct {\n\tName string\n}\n\n" +
+			"// @registry.Targets({\"field\"})\ntype Index struct{}\n\n// @registry.Target({\"field\")\ntype Key struct{}\n\n" +
+			"// Contact owner@example.com (team)\n// @registry.Target({\"field\"})\ntype Contact struct{}\n",
+	})()
+	check := func(name, kind string) (message string) {
+		defer func() {
+			if r := recover(); r != nil {
+				message = fmt.Sprint(r)
+			}
+		}()
+		checkTarget(&AnnotationDoc{Name: name, Position: "a.go:3:1"}, kind, "Pay", "test/meta", nil)
+		return ""
+	}
+	if r := check("Column", "func"); r != "a.go:3:1: Annotation '@Column' can't be applied to func 'Pay', it is allowed only for field, method" {
+		t.Errorf("Incorrect error for annotation target: %s", r)
+	}
+	for _, kind := range []string{"field", "method", "member"} {
+		if r := check("Column", kind); r != "" {
+			t.Errorf("Unexpected error for %s target: %s", kind, r)
+		}
+	}
+	if r := check("Table", "func"); r != "" {
+		t.Errorf("Unexpected error for unrestricted annotation: %s", r)
+	}
+	if r := check("Index", "field"); !strings.HasSuffix(r, "ann.go:16: Unknown meta-annotation '@registry.Targets' of annotation 'Index', did you mean 'Target'?") {
+		t.Errorf("Incorrect error for unknown meta-annotation: %s", r)
+	}
+	if r := check("Key", "func"); r != "" {
+		t.Errorf("Unexpected error for malformed meta-annotation: %s", r)
+	}
+	if r := check("Contact", "field"); r != "" {
+		t.Errorf("Unexpected error for e-mail address in doc comment: %s", r)
+	}
+	if r := check("Contact", "func"); r != "a.go:3:1: Annotation '@Contact' can't be applied to func 'Pay', it is allowed only for field" {
+		t.Errorf("Incorrect error for annotation target: %s", r)
+	}
+}
+
+func TestCheckRepeatedAnnotations(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/rep/ann.go": "package rep\n\nimport _ \"github.com/SphereSoftware/go-annotations/registry\"\n\n" +
+			"type (\n\t// @registry.Repeatable\n\tRole struct {\n\tName string\n\t}\n\tTable struct {\n\tName string\n\t}\n)\n",
+	})()
+	check := func(docs ...AnnotationDoc) (message string) {
+		defer func() {
+			if r := recover(); r != nil {
+				message = fmt.Sprint(r)
+			}
+		}()
+		checkAnnotations(docs, "struct", "Person", "test/rep", nil)
+		return ""
+	}
+	if r := check(AnnotationDoc{Name: "Role"}, AnnotationDoc{Name: "Table"}, AnnotationDoc{Name: "Role"}); r != "" {
+		t.Errorf("Unexpected error for repeatable annotation: %s", r)
+	}
+	if r := check(AnnotationDoc{Name: "Table"}, AnnotationDoc{Name: "Table", Position: "a.go:3:1"}); r != "a.go:3:1: Annotation '@Table' is applied to 'Person' several times but it is not declared as @registry.Repeatable" {
+		t.Errorf("Incorrect error for repeated annotation: %s", r)
+	}
+}
+
+func TestGetAnnotation(t *testing.T) {
+	annotations := []interface{}{Target{[]string{"field"}}, Repeatable{}, Repeatable{}}
+	if a, ok := GetAnnotation(annotations, Target{}).(Target); !ok || a.Kinds[0] != "field" {
+		t.Errorf("Incorrect annotation: %#v", a)
+	}
+	if a := GetAnnotation(annotations, reflect.TypeOf(AnnotationDoc{})); a != nil {
+		t.Errorf("Unexpected annotation: %#v", a)
+	}
+	defer func() {
+		if r := recover(); r == nil {
+			t.Error("Several annotations of the same type are not reported")
+		}
+	}()
+	GetAnnotation(annotations, Repeatable{})
+}
+
+func TestGenerateMetaAnnotations(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/web/ann.go": "package web\n\ntype (\n\t// Marks the controller\n\tController struct{}\n" +
+			"\tResponseBody struct {\n\tFormat string\n\t}\n" +
+			"\t// @Controller\n\t// @ResponseBody(Format=json)\n\tRestController struct{}\n)\n\nconst json = \"json\"\n",
+	})()
+	entries := []AnnotatedEntry{{"struct", "test/app", "Users", AnnotationsData{Self: []AnnotationDoc{{Name: "RestController"}}}}}
+	aliases := newImportAliases("test/app")
+	r := generateMetaAnnotations(entries, "test/app", []string{"test/web"}, aliases)
+	expected := "    _base.MapMeta(\"test/web.RestController\", []interface{} {\n" +
+		"        a1.Controller{\n        },\n        a1.ResponseBody{\n            \"json\",\n        },\n    })\n"
+	if r != expected || len(aliases.packages) != 1 || aliases.packages[0] != "test/web" {
+		t.Errorf("Incorrect meta-annotations: %s %v", r, aliases.packages)
+	}
+}
+
+type (
+	testController   struct{}
+	testResponseBody struct{ Format string }
+	testRest         struct{}
+)
+
+func TestIsAnnotated(t *testing.T) {
+	MapMeta("github.com/SphereSoftware/go-annotations/registry.testRest", []interface{}{testController{}, testResponseBody{"json"}})
+	MapMeta("github.com/SphereSoftware/go-annotations/registry.testController", []interface{}{testController{}})
+	defer delete(metaRegistry, "github.com/SphereSoftware/go-annotations/registry.testRest")
+	defer delete(metaRegistry, "github.com/SphereSoftware/go-annotations/registry.testController")
+	if a := GetMetaAnnotations(testRest{}); len(a) != 2 {
+		t.Errorf("Incorrect meta-annotations: %#v", a)
+	}
+	annotations := []interface{}{testRest{}}
+	if !IsAnnotated(annotations, testController{}) || !IsAnnotated(annotations, reflect.TypeOf(testResponseBody{})) {
+		t.Error("Composed annotations are not found")
+	}
+	if IsAnnotated(annotations, Target{}) || IsAnnotated(nil, testRest{}) {
+		t.Error("Unexpected annotation is found")
+	}
+}
+
+func TestGenerateFuncReference(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/hooks/hooks.go": "package hooks\n\nfunc trim(s string) string {\n\treturn s\n}\n\n" +
+			"func count(s string) int {\n\treturn len(s)\n}\n\nvar notFunc = trim\n",
+	})()
+	fieldType := types.NewSignature(nil, types.NewTuple(types.NewVar(token.NoPos, nil, "s", types.Typ[types.String])),
+		types.NewTuple(types.NewVar(token.NoPos, nil, "", types.Typ[types.String])), false)
+	imports := map[string]string{"strings": "strings"}
+	generate := func(text, own string) (code string) {
+		e := Expr{Text: text, Package: "test/hooks", Imports: imports}
+		if message := panicMessage(func() { code = generateFuncReference(&e, fieldType, newImportAliases(own)) }); message != "" {
+			return message
+		}
+		return code
+	}
+	if r := generate("trim", "test/hooks"); r != "trim" {
+		t.Errorf("Incorrect local function reference: %s", r)
+	}
+	if r := generate("strings.TrimSpace", "test/hooks"); r != "a1.TrimSpace" {
+		t.Errorf("Incorrect qualified function reference: %s", r)
+	}
+	if r := generate("notFunc", "test/hooks"); r != "'notFunc' is not a function" {
+		t.Errorf("Incorrect error for variable: %s", r)
+	}
+	if r := generate("count", "test/hooks"); r != "Function 'count' with signature func(s string) int can't be assigned to annotation field of type func(s string) string" {
+		t.Errorf("Incorrect error for signature mismatch: %s", r)
+	}
+	if r := generate("strings.Missing", "test/hooks"); r != "'strings.Missing' is not found in package 'strings'" {
+		t.Errorf("Incorrect error for unknown function: %s", r)
+	}
+	if r := generate("trim", "test/app"); r != "'trim' is not exported from package 'test/hooks' and can't be referenced by the registry of package 'test/app'" {
+		t.Errorf("Incorrect error for unexported function: %s", r)
+	}
+}
+
+func TestGenerateConstant(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/limits/limits.go": "package limits\n\nconst (\n\tSmall int8 = 1\n\tMedium int16 = 2\n\tKB = 1 << 10\n)\n",
+	})()
+	generate := func(text string, kind types.BasicKind) (code string) {
+		e := Expr{Text: text, Package: "test/limits"}
+		if message := panicMessage(func() { code = generateConstant(&e, types.Typ[kind], newImportAliases("test/app")) }); message != "" {
+			return message
+		}
+		return code
+	}
+	tests := []struct {
+		text     string
+		kind     types.BasicKind
+		expected string
+	}{
+		{"100 + 27", types.Int8, "127"},
+		{"100 + 28", types.Int8, "Constant '100 + 28' = 128 overflows or can't be assigned to annotation field of type int8"},
+		{"-64 * 2", types.Int8, "-128"},
+		{"Small * 200", types.Int8, "Constant 'Small * 200' = 200 overflows or can't be assigned to annotation field of type int8"},
+		{"7 / 2", types.Int, "3"},
+		{"7 / 2", types.Float64, "3"},
+		{"7 / 2.0", types.Float64, "3.5"},
+		{"7 % 0", types.Int, "Division by zero in '7 % 0'"},
+		{"1 << 6", types.Int8, "64"},
+		{"1 << 7", types.Int8, "Constant '1 << 7' = 128 overflows or can't be assigned to annotation field of type int8"},
+		{"KB >> 2", types.Int, "256"},
+		{"1 << -1", types.Int, "Incorrect shift in '1 << -1'"},
+		{"Small << 3", types.Int8, "8"},
+		{"Small + Medium", types.Int16, "Mismatched types int8 and int16 in 'Small + Medium'"},
+		{"Small", types.Int16, "Constant 'Small' of type int8 can't be assigned to annotation field of type int16"},
+		{"Medium", types.Int16, "a1.Medium"},
+		{"4.0 / 2", types.Int, "2"},
+		{"5.0 / 2", types.Int, "Constant '5.0 / 2' = 5/2 overflows or can't be assigned to annotation field of type int"},
+		{"KB * 1.5", types.Int, "1536"},
+		{"--5", types.Int, "Incorrect expression '--5': 1:1: expected operand, found '--'"},
+		{"+-5", types.Int, "-5"},
+	}
+	for _, test := range tests {
+		if r := generate(test.text, test.kind); r != test.expected {
+			t.Errorf("Incorrect constant '%s' of type %s: %s", test.text, types.Typ[test.kind], r)
+		}
+	}
+}
+
+func TestGetTypedLiteral(t *testing.T) {
+	generate := func(value string, kind types.BasicKind) (code string) {
+		if message := panicMessage(func() { code = getTypedLiteral(types.Typ[kind], Literal(value)) }); message != "" {
+			return message
+		}
+		return code
+	}
+	tests := []struct {
+		value    string
+		kind     types.BasicKind
+		expected string
+	}{
+		{"-128", types.Int8, "-128"},
+		{"+127", types.Int8, "+127"},
+		{"-129", types.Int8, "Value '-129' overflows or can't be assigned to annotation field of type int8"},
+		{"1e3", types.Int, "1e3"},
+		{"1.5", types.Int, "Value '1.5' overflows or can't be assigned to annotation field of type int"},
+		{"-1", types.Uint, "Value '-1' overflows or can't be assigned to annotation field of type uint"},
+		{"-2.5", types.Float32, "-2.5"},
+		{"--5", types.Int, "Value '--5' can't be assigned to the value of type int"},
+		{"+-5", types.Float64, "Value '+-5' can't be assigned to the value of type float64"},
+	}
+	for _, test := range tests {
+		if r := generate(test.value, test.kind); r != test.expected {
+			t.Errorf("Incorrect literal '%s' of type %s: %s", test.value, types.Typ[test.kind], r)
+		}
+	}
+	if r := FindAnnotations("@N(V=--5)"); len(r) != 1 || r[0].Content["V"].(Expr).Text != "--5" {
+		t.Errorf("Signed literal is not parsed as expression: %#v", r)
+	}
+}
+
+func TestGenerateMaps(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/maps/ann.go": "package maps\n\ntype (\n\tPerson struct {\n\tName string\n\t}\n" +
+			"\tMetric struct {\n\tLabels map[string]string\n\tOwners map[int]*Person\n\tName string\n\t}\n)\n",
+	})()
+	a := FindAnnotations("@Metric(Labels={\"env\": \"prod\", \"tier\": \"web\"}, Owners={1: @Person(\"ann\")})")[0]
+	expected := "Metric{\n    map[string]string{\n        \"env\": \"prod\",\n        \"tier\": \"web\",\n    },\n" +
+		"    map[int]*Person{\n        1: &Person{\n            \"ann\",\n        },\n    },\n    \"\",\n}"
+	if r := generateCode(a, "test/maps"); r != expected {
+		t.Errorf("Incorrect maps: %s", r)
+	}
+	errors := map[string]string{
+		"@Metric(Labels={\"a\": \"1\", \"a\": \"2\"})": "Attribute 'Labels' of annotation '@Metric': Duplicate key \"a\" in map for annotation field of type map[string]string",
+		"@Metric(Owners={1: @Person, 1: @Person})":     "Attribute 'Owners' of annotation '@Metric': Duplicate key 1 in map for annotation field of type map[int]*test/maps.Person",
+		"@Metric(Owners={\"x\": @Person})":             "Attribute 'Owners' of annotation '@Metric': String \"x\" can't be assigned to the value of type int",
+		"@Metric(Labels={\"a\": @Person})":             "Attribute 'Labels' of annotation '@Metric': Annotation '@Person' can't be assigned to the value of type string",
+		"@Metric(Name={\"a\": \"b\"})":                 "Attribute 'Name' of annotation '@Metric': Map can't be assigned to annotation field of type string",
+	}
+	for doc, message := range errors {
+		if r := generateError(FindAnnotations(doc)[0], "test/maps"); r != message {
+			t.Errorf("Incorrect error for %s: %s", doc, r)
+		}
+	}
+}
+
+func TestGenerateArrays(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/arrays/ann.go": "package arrays\n\ntype (\n\tPerson struct {\n\tName string\n\t}\n" +
+			"\tIndex struct {\n\tColumns [][]string\n\tOwners []*Person\n\tLimits []*int\n\tSizes [3]int\n\t}\n)\n",
+	})()
+	a := FindAnnotations("@Index(Columns={{\"a\", \"b\"}, {\"c\"}}, Owners={@Person(\"x\")}, Limits={5}, Sizes={1, 2})")[0]
+	expected := "Index{\n    [][]string{\n        []string{\n            \"a\",\n            \"b\",\n        },\n" +
+		"        []string{\n            \"c\",\n        },\n    },\n" +
+		"    []*Person{\n        &Person{\n            \"x\",\n        },\n    },\n" +
+		"    []*int{\n        &[]int{5}[0],\n    },\n" +
+		"    [3]int{\n        1,\n        2,\n    },\n}"
+	if r := generateCode(a, "test/arrays"); r != expected {
+		t.Errorf("Incorrect arrays: %s", r)
+	}
+	errors := map[string]string{
+		"@Index(Sizes={1, 2, 3, 4})":      "Attribute 'Sizes' of annotation '@Index': Array of 4 values can't be assigned to the value of type [3]int",
+		"@Index(Sizes={{1}})":             "Attribute 'Sizes' of annotation '@Index': Array of values can't be assigned to the value of type int",
+		"@Index(Columns={\"a\"})":         "Attribute 'Columns' of annotation '@Index': String \"a\" can't be assigned to the value of type []string",
+		"@Index(Owners={@Person, \"x\"})": "Attribute 'Owners' of annotation '@Index': String \"x\" can't be assigned to the value of type test/arrays.Person",
+	}
+	for doc, message := range errors {
+		if r := generateError(FindAnnotations(doc)[0], "test/arrays"); r != message {
+			t.Errorf("Incorrect error for %s: %s", doc, r)
+		}
+	}
+}
+
+func TestGenerateConstantReferences(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/refs/ann.go":     "package refs\n\ntype (\n\tLevel int\n\tCache struct {\n\tTTL int\n\tMethod string\n\tLevel Level\n\t}\n)\n",
+		"test/refs/app/app.go": "package app\n\nimport \"test/refs\"\n\nconst (\n\tMinute = 60\n\tDebug refs.Level = 1\n)\n",
+	})()
+	imports := map[string]string{"http": "net/http"}
+	a := AnnotationDoc{Name: "Cache", Qualifier: "refs", Package: "test/refs", Content: map[string]interface{}{
+		"TTL":    Expr{Text: "Minute * 2", Package: "test/refs/app", Imports: imports},
+		"Method": Expr{Text: "http.MethodGet", Package: "test/refs/app", Imports: imports},
+		"Level":  Expr{Text: "Debug", Package: "test/refs/app", Imports: imports},
+	}}
+	r := generateStruct(&a, "test/refs/app", nil, newImportAliases("test/refs/app"), "")
+	if r != "a1.Cache{\n    120,\n    a2.MethodGet,\n    Debug,\n}" {
+		t.Errorf("Incorrect constant references: %s", r)
+	}
+}
+
+func TestGenerateTypeReferences(t *testing.T) {
+	defer setupTestRoot(t, map[string]string{
+		"test/mapper/ann.go":     "package mapper\n\nimport \"reflect\"\n\ntype Mapper struct {\n\tTarget reflect.Type\n\tSources []reflect.Type\n}\n",
+		"test/mapper/pkg/pkg.go": "package pkg\n\ntype Order struct{}\n",
+		"test/mapper/app/app.go": "package app\n\ntype User struct{}\n",
+	})()
+	imports := map[string]string{"pkg": "test/mapper/pkg"}
+	expr := func(text string) Expr {
+		return Expr{Text: text, Package: "test/mapper/app", Imports: imports}
+	}
+	a := AnnotationDoc{Name: "Mapper", Qualifier: "mapper", Package: "test/mapper", Content: map[string]interface{}{
+		"Target":  expr("User"),
+		"Sources": []Expr{expr("*pkg.Order"), expr("[]string"), expr("map[string][2]User")},
+	}}
+	r := generateStruct(&a, "test/mapper/app", nil, newImportAliases("test/mapper/app"), "")
+	expected := "a1.Mapper{\n    a2.TypeOf((*User)(nil)).Elem(),\n    []a2.Type{\n" +
+		"        a2.TypeOf((**a3.Order)(nil)).Elem(),\n        a2.TypeOf((*[]string)(nil)).Elem(),\n" +
+		"        a2.TypeOf((*map[string][2]User)(nil)).Elem(),\n    },\n}"
+	if r != expected {
+		t.Errorf("Incorrect type references: %s", r)
+	}
+	errors := map[string]string{
+		"Missing":   "Attribute 'Target' of annotation '@Mapper': Type 'Missing' is not found in 'Missing'",
+		"pkg.order": "Attribute 'Target' of annotation '@Mapper': 'pkg.order' is not exported",
+		"chan int":  "Attribute 'Target' of annotation '@Mapper': 'chan int' is not supported as type reference",
+	}
+	for text, message := range errors {
+		a.Content = map[string]interface{}{"Target": expr(text)}
+		if r := panicMessage(func() { generateStruct(&a, "test/mapper/app", nil, newImportAliases("test/mapper/app"), "") }); r != message {
+			t.Errorf("Incorrect error for type reference '%s': %s", text, r)
+		}
+	}
+}
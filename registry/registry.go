@@ -1,14 +1,36 @@
 package registry
 
 import (
+	"encoding"
 	"reflect"
 )
 
 type (
 	// Annotation properties extracted from the comments
 	AnnotationDoc struct {
-		Name    string
-		Content map[string]interface{}
+		Name      string
+		Content   map[string]interface{}
+		Qualifier string // package alias if the name is qualified like @orm.Entity
+		Package   string // full package name resolved for the qualifier
+		Position  string // source position of the comment where annotation is declared
+	}
+
+	// Go expression used as annotation attribute value, like the reference
+	// to the constant LevelDebug or log.LevelDebug
+	Expr struct {
+		Text    string            // expression as it is written in the comment
+		Package string            // full package name of the file where expression is written
+		Imports map[string]string // imports aliases of the file where expression is written
+	}
+
+	// Number or boolean written without quotes as annotation attribute value, like 5 or true.
+	// Quoted values are kept as strings
+	Literal string
+
+	// Key-value pair of the map used as annotation attribute value
+	MapEntry struct {
+		Key   interface{}
+		Value interface{}
 	}
 
 	// Bundle of annotations related to object in source code
@@ -26,6 +48,20 @@ type (
 		AnnotationsData        // related annotation data
 	}
 
+	// Meta-annotation which restricts the targets the annotation can be applied to.
+	// The kinds of targets are struct, interface, func, method, field, const and package.
+	// It is placed in the doc comment of annotation struct like
+	//
+	//	@registry.Target({"field", "method"})
+	Target struct {
+		Kinds []string
+	}
+
+	// Meta-annotation which allows to apply the annotation to the same target several times.
+	// It is placed in the doc comment of annotation struct qualified by the package name
+	// like the meta-annotation Target
+	Repeatable struct{}
+
 	// The annotations bundle stored in Registry for each entry.
 	// It is automatically generated and consists of structs representing custom annotations
 	Annotations struct {
@@ -37,14 +73,40 @@ type (
 
 var (
 	typeRegistry = make(map[string]Annotations)
+	metaRegistry = make(map[string][]interface{})
 )
 
 // Maps annotations bundle to provided string.
-// Usually string contains the type and the name of annotated entry
+// Usually string contains the type and the name of annotated entry.
+// Bundles mapped to the same string (e.g. by the registries of the package
+// and its tests) are combined
 func Map(s string, a Annotations) {
+	if existing, found := typeRegistry[s]; found {
+		a = combineAnnotations(existing, a)
+	}
 	typeRegistry[s] = a
 }
 
+// Returns the bundle with annotations of both provided bundles
+func combineAnnotations(a, b Annotations) Annotations {
+	result := Annotations{
+		Self:    append(append([]interface{}(nil), a.Self...), b.Self...),
+		Fields:  make(map[string][]interface{}),
+		Methods: make(map[string][]interface{}),
+	}
+	for _, m := range []map[string][]interface{}{a.Fields, b.Fields} {
+		for k, v := range m {
+			result.Fields[k] = append(result.Fields[k], v...)
+		}
+	}
+	for _, m := range []map[string][]interface{}{a.Methods, b.Methods} {
+		for k, v := range m {
+			result.Methods[k] = append(result.Methods[k], v...)
+		}
+	}
+	return result
+}
+
 // Maps annotation bundle to the type and name of provided object
 func MapType(i interface{}, a Annotations) {
 	// check whether provided object is a type or an instance
@@ -115,6 +177,79 @@ func GetFuncAnnotation(s interface{}) []interface{} {
 	return nil
 }
 
+// Returns the only annotation of provided type from the annotations bundle
+// (like the result of GetStructAnnotations) or nil if the bundle has no such annotation.
+// Annotation type is given by its instance or reflect.Type.
+// It panics if the bundle contains several annotations of that type
+// (use the bundle itself for repeatable annotations)
+func GetAnnotation(annotations []interface{}, annotationType interface{}) interface{} {
+	typ := getType(annotationType)
+	var result interface{}
+	for _, a := range annotations {
+		if reflect.TypeOf(a) != typ {
+			continue
+		}
+		if result != nil {
+			panic("Several annotations of type " + typ.String() + " are found")
+		}
+		result = a
+	}
+	return result
+}
+
+// Maps meta-annotations to the annotation type given by its full name
+// (the annotations of annotation struct declaration)
+func MapMeta(s string, a []interface{}) {
+	metaRegistry[s] = a
+}
+
+// Returns meta-annotations of provided annotation type given by its instance or reflect.Type
+func GetMetaAnnotations(annotationType interface{}) []interface{} {
+	typ := getType(annotationType)
+	return metaRegistry[typ.PkgPath()+"."+typ.Name()]
+}
+
+// Checks whether the annotations bundle (like the result of GetStructAnnotations)
+// contains the annotation of provided type directly or via composition, i.e. as
+// a meta-annotation of contained annotations (at any depth).
+// Annotation type is given by its instance or reflect.Type
+func IsAnnotated(annotations []interface{}, annotationType interface{}) bool {
+	typ := getType(annotationType)
+	queue := append([]interface{}(nil), annotations...)
+	visited := make(map[reflect.Type]bool)
+	for len(queue) > 0 {
+		a := reflect.TypeOf(queue[0])
+		queue = queue[1:]
+		if a == typ {
+			return true
+		}
+		if !visited[a] {
+			visited[a] = true
+			queue = append(queue, GetMetaAnnotations(a)...)
+		}
+	}
+	return false
+}
+
+// Returns the type given by reflect.Type or by the instance of that type
+func getType(i interface{}) reflect.Type {
+	if t, ok := i.(reflect.Type); ok {
+		return t
+	}
+	return reflect.TypeOf(i)
+}
+
+// Decodes annotation attribute value from the text by provided unmarshaler
+// (a pointer to the value of attribute type) and returns that pointer.
+// It is used by generated registries for the fields of types implementing
+// encoding.TextUnmarshaler (like time.Time); incorrect text causes panic
+func DecodeText(v encoding.TextUnmarshaler, text string) interface{} {
+	if err := v.UnmarshalText([]byte(text)); err != nil {
+		panic("Unable to decode annotation value '" + text + "': " + err.Error())
+	}
+	return v
+}
+
 func findAnnotationsByType(s interface{}) (*Annotations, bool) {
 	var path string
 	switch t := s.(type) {
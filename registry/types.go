@@ -0,0 +1,111 @@
+package registry
+
+import (
+	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path/filepath"
+	"strings"
+)
+
+type (
+	// Importer which type-checks imported packages from their sources
+	sourceImporter struct{}
+)
+
+var (
+	typesFileSet = token.NewFileSet()
+	loadedTypes  = make(map[string]*types.Package)
+	// The package which test files are type-checked together with it
+	// while the registries of its tests are generated
+	testedPackage string
+)
+
+func (sourceImporter) Import(path string) (*types.Package, error) {
+	return importTypes(path, ""), nil
+}
+
+func (sourceImporter) ImportFrom(path, dir string, mode types.ImportMode) (*types.Package, error) {
+	return importTypes(path, dir), nil
+}
+
+// Returns type information of the package by its full name.
+// Package is type-checked from its sources. Type checking errors are ignored
+// so the package with outdated annotations registry still can be used
+func loadTypes(pck string) *types.Package {
+	return importTypes(pck, "")
+}
+
+// Returns type information of the package imported from provided folder.
+// Packages are searched in GOPATH first, then in standard library
+// (taking into account its vendored packages).
+// The tested package (see testedPackage) includes its test files and
+// its external test package (with "_test" suffix) is loaded from the same folder
+func importTypes(pck, srcDir string) *types.Package {
+	if pck == "unsafe" {
+		return types.Unsafe
+	}
+	var bp *build.Package
+	var err error
+	ctx := getBuildContext()
+	xTest := isExternalTest(pck)
+	dirs := findDirs(pck)
+	if xTest {
+		dirs = findDirs(testedPackage)
+	}
+	if len(dirs) > 0 {
+		bp, err = ctx.ImportDir(dirs[0], 0)
+	} else {
+		bp, err = ctx.Import(pck, srcDir, 0)
+	}
+	if err != nil {
+		panic("Can't find sources of package '" + pck + "': " + err.Error())
+	}
+	if len(dirs) > 0 {
+		bp.ImportPath = pck
+	}
+	// files importing "C" are the sources of the package as well
+	key, sources := bp.Dir, append(append([]string(nil), bp.GoFiles...), bp.CgoFiles...)
+	switch {
+	case xTest:
+		key, sources = bp.Dir+" (x_test)", bp.XTestGoFiles
+	case pck == testedPackage:
+		key, sources = bp.Dir+" (test)", append(sources, bp.TestGoFiles...)
+	}
+	p, found := loadedTypes[key]
+	if found {
+		return p
+	}
+	var files []*ast.File
+	for _, name := range sources {
+		source := filepath.Join(bp.Dir, name)
+		fileNode, err := parser.ParseFile(typesFileSet, source, nil, 0)
+		if err != nil {
+			panic("Error while parse source file " + source + ":\n" + err.Error())
+		}
+		files = append(files, fileNode)
+	}
+	conf := types.Config{
+		Importer:         sourceImporter{},
+		IgnoreFuncBodies: true,
+		FakeImportC:      true,
+		Error:            func(err error) {},
+	}
+	p, _ = conf.Check(bp.ImportPath, typesFileSet, files, nil)
+	loadedTypes[key] = p
+	return p
+}
+
+// Checks whether provided package is the external test package of the tested package
+func isExternalTest(pck string) bool {
+	return testedPackage != "" && pck == testedPackage+"_test"
+}
+
+// Returns the name of provided type qualified by the aliases of its packages
+func getTypeName(t types.Type, aliases *importAliases) string {
+	return types.TypeString(t, func(p *types.Package) string {
+		return strings.TrimSuffix(aliases.qualifier(p.Path()), ".")
+	})
+}
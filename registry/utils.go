@@ -3,8 +3,13 @@ package registry
 import (
 	"bufio"
 	"go/ast"
+	"go/build"
+	"go/parser"
+	"go/token"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 )
 
@@ -12,6 +17,39 @@ var (
 	ROOTS = filepath.SplitList(os.Getenv("GOPATH"))
 )
 
+// Returns the context for selecting source files satisfying build constraints
+// for current platform and the build tags given by BUILD_TAGS option
+func getBuildContext() *build.Context {
+	ctx := build.Default
+	ctx.BuildTags = strings.FieldsFunc(BUILD_TAGS, func(c rune) bool {
+		return c == ',' || c == ' '
+	})
+	return &ctx
+}
+
+// Returns the package in provided folder with the sources (including tests)
+// which satisfy build constraints
+func getBuildPackage(dir string) *build.Package {
+	bp, err := getBuildContext().ImportDir(dir, 0)
+	if err != nil {
+		if _, ok := err.(*build.NoGoError); ok {
+			return &build.Package{Dir: dir}
+		}
+		panic("Can't select source files in " + dir + ": " + err.Error())
+	}
+	return bp
+}
+
+// Returns sorted names of fields or methods which annotations are given by provided map
+func sortedNames(annotations map[string][]AnnotationDoc) []string {
+	names := make([]string, 0, len(annotations))
+	for name := range annotations {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
 // Combines two sets of packages names keeping only unique names.
 // Returns the combined set of names.
 func combinePackages(allPackages []string, foundPackages []string) []string {
@@ -53,6 +91,31 @@ func findDirs(pck string) []string {
 	return foundPath
 }
 
+// Returns the name of the package declared in its source files.
+// If package sources are not found then the last element of its path is returned
+// Parameter:
+// - pck - full package name
+func getPackageName(pck string) string {
+	for _, dir := range findDirs(pck) {
+		files, err := ioutil.ReadDir(dir)
+		if err != nil {
+			panic(err)
+		}
+		for _, file := range files {
+			fileName := file.Name()
+			if file.IsDir() || !strings.HasSuffix(fileName, ".go") || strings.HasSuffix(fileName, "_test.go") {
+				continue
+			}
+			fset := token.NewFileSet()
+			fileNode, err := parser.ParseFile(fset, filepath.Join(dir, fileName), nil, parser.PackageClauseOnly)
+			if err == nil {
+				return fileNode.Name.Name
+			}
+		}
+	}
+	return pck[strings.LastIndex(pck, "/")+1:]
+}
+
 // Saves provided text content to buffered writer
 // Parameters:
 // - pointer to buffered writer
@@ -77,6 +140,10 @@ func resolveFullPackage(path, shortPackage string) string {
 		if strings.HasPrefix(path, root) {
 			pck := strings.Replace(strings.TrimPrefix(path, root), "\\", "/", -1)
 			pck = strings.TrimPrefix(pck, "/src/")
+			// external test package
+			if shortPackage == pck[strings.LastIndex(pck, "/")+1:]+"_test" {
+				pck += "_test"
+			}
 			return pck
 		}
 	}
@@ -93,3 +160,49 @@ func getFieldName(f *ast.Field) string {
 	}
 	return f.Names[0].Name
 }
+
+// Returns the name from the list which is the most similar to provided one
+// (differs by case or by few characters) or empty string if there is no such name
+func findSimilar(name string, names []string) string {
+	similar := ""
+	// allowed number of edits depends on the length of the name
+	best := len(name)/4 + 2
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return n
+		}
+		if d := editDistance(strings.ToLower(n), strings.ToLower(name)); d < best {
+			similar, best = n, d
+		}
+	}
+	return similar
+}
+
+// Returns Levenshtein distance between two strings
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	row := make([]int, len(rb)+1)
+	for j := range row {
+		row[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		prev := row[0]
+		row[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cur := row[j]
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			row[j] = prev + cost
+			if row[j-1]+1 < row[j] {
+				row[j] = row[j-1] + 1
+			}
+			if cur+1 < row[j] {
+				row[j] = cur + 1
+			}
+			prev = cur
+		}
+	}
+	return row[len(rb)]
+}
@@ -2,373 +2,806 @@ package registry
 
 import (
 	"bytes"
+	"fmt"
 	"go/ast"
+	"go/constant"
 	"go/parser"
 	"go/token"
-	"io/ioutil"
+	"go/types"
 	"log"
-	"path/filepath"
 	"reflect"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 )
 
-// Returns annotated entry annotations descriptor and list of all annotations packages in it
+// Returns annotated entry annotations descriptor
 // Parameters:
 // - annotated entry
 // - full package name
 // - list of imports in the entry source
-func GenerateAnnotationValue(a *AnnotatedEntry, packageName string, foundImports []string) (string, []string) {
+// - aliases of packages imported by generated registry
+func GenerateAnnotationValue(a *AnnotatedEntry, packageName string, foundImports []string, aliases *importAliases) string {
 	var b bytes.Buffer
-	var allPackages []string
 	log.Printf("Generating annotations values in package %s for %s %s\n", packageName, a.Type, a.Name)
 	// write self
 	if len(a.AnnotationsData.Self) > 0 {
 		log.Printf("Self : %d\n", len(a.AnnotationsData.Self))
 	}
 	b.WriteString("        _base.Annotations {\n            Self: []interface{} {\n")
+	checkAnnotations(a.AnnotationsData.Self, a.Type, a.Name, packageName, foundImports)
 	for _, self := range a.AnnotationsData.Self {
-		s, packages := generateStruct(&self, packageName, foundImports, "                ")
-		allPackages = combinePackages(allPackages, packages)
-		b.WriteString(s)
+		b.WriteString(generateStruct(&self, packageName, foundImports, aliases, "                "))
 		b.WriteString(",\n")
 	}
 	b.WriteString("            },\n            Fields: map[string][]interface{} {\n")
-	for field, fieldAnnotations := range a.AnnotationsData.Fields {
+	for _, field := range sortedNames(a.AnnotationsData.Fields) {
+		fieldAnnotations := a.AnnotationsData.Fields[field]
 		if len(fieldAnnotations) > 0 {
 			log.Printf("Field .%s: %d\n", field, len(fieldAnnotations))
 		}
 		b.WriteString("                " + strconv.Quote(field) + ": []interface{} {\n")
+		// methods of structs are stored together with fields
+		checkAnnotations(fieldAnnotations, getMemberKind(a.FullPackage, a.Name, field), a.Name+"."+field, packageName, foundImports)
 		for _, an := range fieldAnnotations {
-			s, packages := generateStruct(&an, packageName, foundImports, "                    ")
-			allPackages = combinePackages(allPackages, packages)
-			b.WriteString(s)
+			b.WriteString(generateStruct(&an, packageName, foundImports, aliases, "                    "))
 			b.WriteString(",\n")
 		}
 		b.WriteString("                },\n")
 	}
 	b.WriteString("            },\n            Methods: map[string][]interface{} {\n")
-	for method, methodAnnotations := range a.AnnotationsData.Methods {
+	for _, method := range sortedNames(a.AnnotationsData.Methods) {
+		methodAnnotations := a.AnnotationsData.Methods[method]
 		if len(methodAnnotations) > 0 {
 			log.Printf("Method %s(): %d\n", method, len(methodAnnotations))
 		}
 		b.WriteString("                " + strconv.Quote(method) + ": []interface{} {\n")
+		checkAnnotations(methodAnnotations, "method", a.Name+"."+method, packageName, foundImports)
 		for _, an := range methodAnnotations {
-			s, packages := generateStruct(&an, packageName, foundImports, "                    ")
-			allPackages = combinePackages(allPackages, packages)
-			b.WriteString(s)
+			b.WriteString(generateStruct(&an, packageName, foundImports, aliases, "                    "))
 			b.WriteString(",\n")
 		}
 		b.WriteString("},\n")
 	}
 	b.WriteString("}}")
-	return b.String(), allPackages
+	return b.String()
 }
 
 // Generates structure initialization for provided annotation.
-// Returns the generated code and list of packages used for
-// all enclosed annotations types.
-// Annotations types inside the code will be prefixed by full
-// package name.
+// Annotations types inside the code will be qualified by
+// the aliases of their packages.
 // Parameters:
 // - annotation instance
 // - full package name where given instance is found
 // - list of imports found in the file containing the annotated entry
+// - aliases of packages imported by generated registry
 // - string of spaces for idents
-func generateStruct(a *AnnotationDoc, packageName string, imports []string, indent string) (string, []string) {
-	var allAnnotationsPackages []string
-	possiblePackagesForA := combinePackages(imports, []string{packageName})
-	ts, foundPackageOfA, foundImportsOfA := getAnnotationStruct(a.Name, possiblePackagesForA)
-	allAnnotationsPackages = combinePackages(allAnnotationsPackages, []string{foundPackageOfA})
-	str, _ := ts.Type.(*ast.StructType)
+func generateStruct(a *AnnotationDoc, packageName string, imports []string, aliases *importAliases, indent string) string {
+	if a.Position != "" {
+		defer func() {
+			if r := recover(); r != nil {
+				panic(fmt.Sprintf("%s: %v", a.Position, r))
+			}
+		}()
+	}
+	possiblePackagesForA := annotationPackages(a, combinePackages(imports, []string{packageName}))
+	obj, foundPackageOfA, foundImportsOfA := getAnnotationStruct(a.Name, possiblePackagesForA)
+	str := obj.Type().Underlying().(*types.Struct)
 	var b bytes.Buffer
 	b.WriteString(indent)
-	b.WriteString(foundPackageOfA)
-	b.WriteString(".")
+	b.WriteString(aliases.qualifier(foundPackageOfA))
 	b.WriteString(a.Name)
 	b.WriteString("{\n")
 	childIndent := indent + "    "
-	for _, f := range str.Fields.List {
-		fieldName := getFieldName(f)
-		defValue := getDefaultValue(f)
-		fieldKey := fieldName
-		// consider special case when only default parameter is specified
-		if len(str.Fields.List) == 1 && len(a.Content) == 1 {
-			for key := range a.Content {
-				if key == DEFAULT_PARAM {
-					fieldKey = DEFAULT_PARAM
+	values := resolveAttributes(a, str)
+	for i := 0; i < str.NumFields(); i++ {
+		value, found := values[i]
+		b.WriteString(childIndent)
+		b.WriteString(generateAttribute(a, str.Field(i), value, found, str.Tag(i), foundPackageOfA, foundImportsOfA, aliases, childIndent))
+		b.WriteString(",\n")
+	}
+	b.WriteString(indent)
+	b.WriteString("}")
+	return b.String()
+}
+
+// Returns the names of attributes for all fields of annotation struct
+// and the index of the field which receives the value without attribute name (or -1).
+// Attribute name is the field name unless it is given by the tag `annotation:"name,alias..."`
+// which can also define aliases of the attribute. The option 'value' in that tag
+// (like `annotation:",value"`) marks the field for the value without name.
+// Panics if attribute names collide (ignoring case in case-insensitive mode)
+func getAttributeNames(a *AnnotationDoc, str *types.Struct) ([][]string, int) {
+	var names [][]string
+	valueField := -1
+	fields := make(map[string]string)
+	for i := 0; i < str.NumFields(); i++ {
+		f := str.Field(i)
+		if f.Embedded() {
+			panic("Unnamed fields are not supported in annotations")
+		}
+		fieldNames := []string{f.Name()}
+		if tag := reflect.StructTag(str.Tag(i)).Get("annotation"); tag != "" {
+			parts := strings.Split(tag, ",")
+			if parts[0] != "" {
+				fieldNames[0] = parts[0]
+			}
+			for _, alias := range parts[1:] {
+				switch {
+				case alias == "value" && valueField >= 0:
+					panic("Fields '" + str.Field(valueField).Name() + "' and '" + f.Name() +
+						"' of annotation '@" + a.Name + "' are both marked to receive the value without name")
+				case alias == "value":
+					valueField = i
+				case alias != "":
+					fieldNames = append(fieldNames, alias)
 				}
 			}
 		}
-		value, found := a.Content[fieldKey]
-		if found {
-			switch t := value.(type) {
-			case string:
-				b.WriteString(childIndent)
-				b.WriteString(getLiteral(f.Type, t, false))
-				b.WriteString(",\n")
-			case []string:
-				b.WriteString(childIndent)
-				b.WriteString(getFieldConstructor(f.Type))
-				b.WriteString("\n")
-				for _, elem := range t {
-					b.WriteString(childIndent + "    ")
-					b.WriteString(elem)
-					b.WriteString(",\n")
-				}
-				b.WriteString(childIndent)
-				b.WriteString("}")
-			case []AnnotationDoc:
-				// calculate array's elements
-				var bb bytes.Buffer
-				for _, sa := range t {
-					childCode, foundImportsOfChild := generateStruct(&sa, foundPackageOfA, foundImportsOfA, childIndent+"    ")
-					allAnnotationsPackages = combinePackages(allAnnotationsPackages, foundImportsOfChild)
-					bb.WriteString(childCode)
-					bb.WriteString(",\n")
-				}
-				b.WriteString(childIndent)
-				// insert array initialzer of child annotation type
-				s := writeArrayInitializer(&b, bb.String())
-				// append array of child annotations
-				b.WriteString("{\n")
-				b.WriteString(childIndent + "    ")
-				b.WriteString(s)
-				b.WriteString(childIndent)
-				b.WriteString("},\n")
-			case AnnotationDoc:
-				childCode, foundImportsOfChild := generateStruct(&t, foundPackageOfA, foundImportsOfA, childIndent)
-				allAnnotationsPackages = combinePackages(allAnnotationsPackages, foundImportsOfChild)
-				b.WriteString(childIndent)
-				if isOptional(f.Type) {
-					b.WriteString("&")
-				}
-				b.WriteString(strings.TrimLeft(childCode, " "))
-				b.WriteString(",\n")
-			default:
-				panic("Unexpected annotation value type")
+		for _, name := range fieldNames {
+			key := attributeKey(name)
+			if field, found := fields[key]; found && field != f.Name() {
+				panic("Attribute '" + name + "' of annotation '@" + a.Name + "' is defined by fields '" +
+					field + "' and '" + f.Name() + "'")
+			}
+			fields[key] = f.Name()
+		}
+		names = append(names, fieldNames)
+	}
+	if valueField < 0 && len(names) == 1 {
+		valueField = 0
+	}
+	return names, valueField
+}
+
+// Returns the index of the field for the attribute given by its key in annotation content
+// (the name or DEFAULT_PARAM for the value without name) and false if there is no such field.
+// Parameters are the names of attributes and the index of value field returned by getAttributeNames
+func findAttribute(key string, names [][]string, valueField int) (int, bool) {
+	if key == DEFAULT_PARAM {
+		return valueField, valueField >= 0
+	}
+	for i := range names {
+		for _, name := range names[i] {
+			if attributeKey(name) == attributeKey(key) {
+				return i, true
 			}
-		} else {
-			b.WriteString(childIndent)
-			b.WriteString(defValue)
-			b.WriteString(",\n")
 		}
 	}
+	return -1, false
+}
+
+// Returns the key which identifies the attribute by its name taking into account IGNORE_CASE option
+func attributeKey(name string) string {
+	if IGNORE_CASE {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// Returns the values of annotation attributes by the indexes of annotation struct fields.
+// Panics if the annotation contains unknown attributes or the same attribute several times,
+// the value without attribute name is specified for the struct with several fields
+// or required attributes (marked by the tag `required:"true"`) are missing
+func resolveAttributes(a *AnnotationDoc, str *types.Struct) map[int]interface{} {
+	names, valueField := getAttributeNames(a, str)
+	var allNames []string
+	for i := range names {
+		allNames = append(allNames, names[i]...)
+	}
+	var keys []string
+	for key := range a.Content {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	values := make(map[int]interface{})
+	specified := make(map[int]string)
+	for _, key := range keys {
+		i, found := findAttribute(key, names, valueField)
+		if key == DEFAULT_PARAM && !found {
+			panic("Annotation '@" + a.Name + "' has " + strconv.Itoa(len(names)) +
+				" attributes, the name of attribute should be specified for its value" +
+				" or the field for it should be marked by the tag `annotation:\",value\"`")
+		}
+		if !found {
+			message := "Annotation '@" + a.Name + "' has no attribute '" + key + "'"
+			if similar := findSimilar(key, allNames); similar != "" {
+				message += ", did you mean '" + similar + "'?"
+			}
+			panic(message)
+		}
+		name := key
+		if key == DEFAULT_PARAM {
+			name = "value without name"
+		}
+		if previous, found := specified[i]; found {
+			panic("Attribute '" + names[i][0] + "' of annotation '@" + a.Name + "' is specified twice as '" +
+				previous + "' and '" + name + "'")
+		}
+		specified[i] = name
+		values[i] = a.Content[key]
+	}
+	var missing []string
+	for i := range names {
+		if _, found := values[i]; !found && reflect.StructTag(str.Tag(i)).Get("required") == "true" {
+			missing = append(missing, "'"+names[i][0]+"'")
+		}
+	}
+	if len(missing) == 1 {
+		panic("Required attribute " + missing[0] + " of annotation '@" + a.Name + "' is not specified")
+	} else if len(missing) > 1 {
+		panic("Required attributes " + strings.Join(missing, ", ") + " of annotation '@" + a.Name + "' are not specified")
+	}
+	return values
+}
+
+// Generates the value of annotation attribute given explicitly or by default.
+// Errors are reported with the name of attribute and annotation
+func generateAttribute(a *AnnotationDoc, f *types.Var, value interface{}, found bool, tag, packageName string, imports []string, aliases *importAliases, indent string) string {
+	defer func() {
+		if r := recover(); r != nil {
+			panic(fmt.Sprintf("Attribute '%s' of annotation '@%s': %v", f.Name(), a.Name, r))
+		}
+	}()
+	if found {
+		return generateValue(value, f.Type(), packageName, imports, aliases, indent)
+	}
+	return getDefaultValue(f, tag, packageName, imports, aliases, indent)
+}
+
+// Generates the map initialization from provided entries.
+// Parameters:
+// - list of map entries
+// - type of annotation field which should be a map
+// - full package name and imports of annotation struct (used for enclosed annotations)
+// - aliases of packages imported by generated registry
+// - string of spaces for idents
+func generateMap(entries []MapEntry, fieldType types.Type, packageName string, imports []string, aliases *importAliases, indent string) string {
+	m, ok := fieldType.Underlying().(*types.Map)
+	if !ok {
+		panic("Map can't be assigned to annotation field of type " + fieldType.String())
+	}
+	keys := make(map[string]bool)
+	var b bytes.Buffer
+	b.WriteString(getTypeName(fieldType, aliases))
+	b.WriteString("{\n")
+	for _, entry := range entries {
+		key := generateValue(entry.Key, m.Key(), packageName, imports, aliases, indent+"    ")
+		if keys[key] {
+			panic("Duplicate key " + key + " in map for annotation field of type " + fieldType.String())
+		}
+		keys[key] = true
+		b.WriteString(indent + "    ")
+		b.WriteString(key)
+		b.WriteString(": ")
+		b.WriteString(generateValue(entry.Value, m.Elem(), packageName, imports, aliases, indent+"    "))
+		b.WriteString(",\n")
+	}
 	b.WriteString(indent)
 	b.WriteString("}")
-	return b.String(), allAnnotationsPackages
+	return b.String()
 }
 
-// Writes array initializer for type X as "[]X {" into provided text buffer
+// Generates the value of provided type from attribute value.
 // Parameters:
-// - address of text buffer to write
-// - structural constructor text starting from spaces
-func writeArrayInitializer(b *bytes.Buffer, s string) string {
-	start := 0
-	n := len(s)
-	for start < n && s[start] == ' ' {
-		start++
-	}
-	if start < n {
-		s = s[start:]
-		pos := strings.Index(s, "{")
-		b.WriteString("[]")
-		b.WriteString(s[:pos])
-		return s
-	} else {
-		panic("Empty annotation is returned")
-	}
-}
-
-// Returns true if given type is a pointer
-func isOptional(e ast.Expr) bool {
-	switch e.(type) {
-	case *ast.StarExpr:
-		return true
+// - attribute value
+// - type of generated value
+// - full package name and imports of annotation struct (used for enclosed annotations)
+// - aliases of packages imported by generated registry
+// - string of spaces for idents
+func generateValue(value interface{}, t types.Type, packageName string, imports []string, aliases *importAliases, indent string) string {
+	switch v := value.(type) {
+	case string:
+		if code, ok := generateDecodedValue(v, t, aliases); ok {
+			return code
+		}
+		if p, ok := t.(*types.Pointer); ok {
+			return getAddressOf(getStringLiteral(p.Elem(), v), p.Elem(), aliases)
+		}
+		return getStringLiteral(t, v)
+	case Literal:
+		if p, ok := t.(*types.Pointer); ok {
+			return getAddressOf(getTypedLiteral(p.Elem(), v), p.Elem(), aliases)
+		}
+		return getTypedLiteral(t, v)
+	case Expr:
+		if p, ok := t.(*types.Pointer); ok && !isReflectType(t) {
+			return getAddressOf(generateExpr(&v, p.Elem(), aliases), p.Elem(), aliases)
+		}
+		return generateExpr(&v, t, aliases)
+	case AnnotationDoc:
+		checkAnnotationType(&v, t, packageName, imports)
+		code := strings.TrimLeft(generateStruct(&v, packageName, imports, aliases, indent), " ")
+		if _, ok := t.(*types.Pointer); ok {
+			code = "&" + code
+		}
+		return code
+	case []MapEntry:
+		return generateMap(v, t, packageName, imports, aliases, indent)
+	case []interface{}:
+		if _, ok := t.Underlying().(*types.Map); ok && len(v) == 0 {
+			// empty braces {} for the map
+			return generateMap(nil, t, packageName, imports, aliases, indent)
+		}
+		return generateArray(v, t, packageName, imports, aliases, indent)
+	case []string:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+		return generateArray(values, t, packageName, imports, aliases, indent)
+	case []Literal:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+		return generateArray(values, t, packageName, imports, aliases, indent)
+	case []Expr:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+		return generateArray(values, t, packageName, imports, aliases, indent)
+	case []AnnotationDoc:
+		values := make([]interface{}, len(v))
+		for i := range v {
+			values[i] = v[i]
+		}
+		return generateArray(values, t, packageName, imports, aliases, indent)
 	}
-	return false
+	panic("Unexpected annotation value type")
 }
 
-// Returns TypeSpec for the annotation struct, its package and list of imports
-// from the file where that struct is defined.
-func getAnnotationStruct(name string, possiblePackages []string) (*ast.TypeSpec, string, []string) {
-	var result *ast.TypeSpec
-	var foundPackage string
-	var foundImports []string
-	var foundDir string
-	for _, pck := range possiblePackages {
-		for _, dir := range findDirs(pck) {
-			// get all go sources from package folder
-			files, err := ioutil.ReadDir(dir)
-			if err != nil {
-				panic(err)
-			}
-			for _, file := range files {
-				if !file.IsDir() && strings.HasSuffix(file.Name(), ".go") {
-					source := filepath.Join(dir, file.Name())
-					// parse source file
-					fset := token.NewFileSet()
-					fileNode, err := parser.ParseFile(fset, source, nil, parser.ParseComments)
-					if err != nil {
-						panic("Error while parse source file " + source + ":\n" + err.Error())
-					}
-					for _, decl := range fileNode.Decls {
-						gd, ok := decl.(*ast.GenDecl)
-						if !ok {
-							continue
-						}
-						for _, spec := range gd.Specs {
-							ts, ok := spec.(*ast.TypeSpec)
-							if !ok {
-								is, ok := spec.(*ast.ImportSpec)
-								if !ok {
-									continue
-								}
-								v := is.Path.Value
-								if strings.HasPrefix(v, "\"") && strings.HasSuffix(v, "\"") {
-									v = v[1 : len(v)-1]
-								}
-								foundImports = combinePackages(foundImports, []string{v})
-							} else {
-								str, ok := ts.Type.(*ast.StructType)
-								if !ok || str.Incomplete {
-									continue
-								}
-								if ts.Name.Name == name {
-									if result == nil {
-										result = ts
-										foundPackage = pck
-										foundDir = dir
-										break
-									} else {
-										panicReason(name, dir, foundDir, pck, foundPackage)
-									}
-								}
-							}
-						}
-					}
-				}
-			}
+// Generates the initialization of slice or fixed-size array from provided values.
+// Parameters:
+// - list of values
+// - type of slice or array
+// - full package name and imports of annotation struct (used for enclosed annotations)
+// - aliases of packages imported by generated registry
+// - string of spaces for idents
+func generateArray(values []interface{}, t types.Type, packageName string, imports []string, aliases *importAliases, indent string) string {
+	var elemType types.Type
+	switch a := t.Underlying().(type) {
+	case *types.Slice:
+		elemType = a.Elem()
+	case *types.Array:
+		if int64(len(values)) > a.Len() {
+			panic("Array of " + strconv.Itoa(len(values)) + " values can't be assigned to the value of type " + t.String())
 		}
+		elemType = a.Elem()
+	default:
+		panic("Array of values can't be assigned to the value of type " + t.String())
 	}
-	if result != nil {
-		return result, foundPackage, foundImports
+	var b bytes.Buffer
+	b.WriteString(getTypeName(t, aliases))
+	b.WriteString("{\n")
+	for _, value := range values {
+		b.WriteString(indent + "    ")
+		b.WriteString(generateValue(value, elemType, packageName, imports, aliases, indent+"    "))
+		b.WriteString(",\n")
 	}
-	panic("Annotation source for '" + name + "' is not found")
+	b.WriteString(indent)
+	b.WriteString("}")
+	return b.String()
+}
+
+// Returns the code of addressable value for provided code of the value
+func getAddressOf(code string, t types.Type, aliases *importAliases) string {
+	return "&[]" + getTypeName(t, aliases) + "{" + code + "}[0]"
+}
+
+// Checks that the struct of enclosed annotation matches provided type.
+// The type can be the pointer to the struct or an interface
+// Parameters:
+// - enclosed annotation
+// - the type of the value where the annotation is assigned
+// - full package name and imports of annotation struct which contains enclosed one
+func checkAnnotationType(a *AnnotationDoc, t types.Type, packageName string, imports []string) {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	if _, ok := t.Underlying().(*types.Interface); ok {
+		return
+	}
+	obj, _, _ := getAnnotationStruct(a.Name, annotationPackages(a, combinePackages(imports, []string{packageName})))
+	if !types.Identical(t, obj.Type()) {
+		panic("Annotation '@" + a.Name + "' can't be assigned to the value of type " + t.String())
+	}
+}
+
+// Returns the literal of provided string type for quoted value
+func getStringLiteral(t types.Type, value string) string {
+	if b, ok := t.Underlying().(*types.Basic); ok && b.Info()&types.IsString != 0 {
+		return strconv.Quote(value)
+	}
+	panic("String " + strconv.Quote(value) + " can't be assigned to the value of type " + t.String())
 }
 
-// Makes the panic call with the reason corresponding to situation
-func panicReason(name, dir, foundDir, pck, foundPackage string) {
-	if dir == foundDir {
-		panic("Ambiguous reference to annotation '" + name +
-			"'\nIt exists in packages '" + foundPackage +
-			"' and '" + pck + "'")
-	} else if pck == foundPackage {
-		panic("Ambiguous reference to annotation '" + name +
-			":\nthe same package '" + pck + "' exists in folders:\n'" + dir +
-			"'\n and in folder \n'" + foundDir + "'")
-	} else {
-		panic("Ambiguous reference to annotation '" + name + "':\n" +
-			"- " + dir + " / " + pck + "\n - " + foundDir + " / " + foundPackage)
-	}
-}
-
-// Extracts default value for the field from its tag
-// default value is stored in form `deafult:"XXX"`
-func getDefaultValue(f *ast.Field) string {
-	if f.Tag != nil {
-		tag := f.Tag.Value
-		n := len(tag) - 1
-		if n > 1 {
-			tag := reflect.StructTag(tag[1:n]).Get("default")
-			if len(tag) > 0 {
-				return getLiteral(f.Type, tag, false)
+// Returns the literal of provided basic type for unquoted number or boolean value
+func getTypedLiteral(t types.Type, literal Literal) string {
+	value := string(literal)
+	b, ok := t.Underlying().(*types.Basic)
+	if ok {
+		switch {
+		case b.Info()&types.IsBoolean != 0:
+			if value == "true" || value == "false" {
+				return value
+			}
+		case b.Info()&(types.IsInteger|types.IsFloat) != 0:
+			// float literal like 1e3 is assigned to integer field if it has no fractional part
+			if isNumber(value, token.INT) || isNumber(value, token.FLOAT) {
+				checkOverflow(value, t, b)
+				return value
 			}
 		}
 	}
-	return getZeroLiteral(f.Type)
+	panic("Value '" + value + "' can't be assigned to the value of type " + t.String())
 }
 
-// Returns the literal value representation beased on its type
-func getLiteral(e ast.Expr, value string, wasPointer bool) string {
-	switch t := e.(type) {
-	case *ast.StarExpr:
-		if wasPointer {
-			panic("Poniter to pointers should not be used as annotation field")
+// Generates the value of time.Duration field given like "5s" or the value
+// of the field which type implements encoding.TextUnmarshaler (like time.Time).
+// The latter is decoded in generated code, values of known types are validated
+// at generation time. Returns generated code and false if the value is not decoded
+func generateDecodedValue(value string, t types.Type, aliases *importAliases) (string, bool) {
+	elem := t
+	p, pointer := t.(*types.Pointer)
+	if pointer {
+		elem = p.Elem()
+	}
+	switch {
+	case isNamedType(elem, "time", "Duration"):
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			panic("Incorrect duration '" + value + "': " + err.Error())
 		}
-		return getLiteral(t.X, value, true)
-	case *ast.Ident:
-		switch t.Name {
-		case "string":
-			return strconv.Quote(value)
-		case "int", "int8", "int16", "int32", "int64",
-			"uint", "uint8", "uint16", "uint32", "uint64",
-			"float32", "float64", "byte", "rune":
-			return value
-		default:
-			panic("Type '" + t.Name + "' doesn't support default value for annotation field")
+		code := strconv.FormatInt(int64(d), 10)
+		if pointer {
+			code = getAddressOf(code, elem, aliases)
 		}
-	default:
-		panic("Unsupported field type in annotation definition")
+		return code, true
+	case isTextUnmarshaler(elem):
+		if isNamedType(elem, "time", "Time") {
+			if _, err := time.Parse(time.RFC3339Nano, value); err != nil {
+				panic("Incorrect time '" + value + "': " + err.Error())
+			}
+		}
+		typeName := getTypeName(elem, aliases)
+		code := "_base.DecodeText(new(" + typeName + "), " + strconv.Quote(value) + ").(*" + typeName + ")"
+		if !pointer {
+			code = "*" + code
+		}
+		return code, true
 	}
+	return "", false
 }
 
-// Returns literal representatin of zero value for provided type
-func getZeroLiteral(e ast.Expr) string {
-	switch t := e.(type) {
-	case *ast.StarExpr:
-		return "nil"
-	case *ast.Ident:
-		switch t.Name {
-		case "string":
-			return "\"\""
-		case "int", "int8", "int16", "int32", "int64",
-			"uint", "uint8", "uint16", "uint32", "uint64",
-			"float32", "float64", "byte", "rune":
-			return "0"
-		default:
-			panic("Type '" + t.Name + "' doesn't support default value for annotation field")
+// Returns true if provided type is the named type declared in given package
+func isNamedType(t types.Type, pck, name string) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == pck && named.Obj().Name() == name
+}
+
+// Returns true if the pointer to the value of provided type implements encoding.TextUnmarshaler
+func isTextUnmarshaler(t types.Type) bool {
+	if _, ok := t.Underlying().(*types.Interface); ok {
+		return false
+	}
+	unmarshaler := loadTypes("encoding").Scope().Lookup("TextUnmarshaler").Type().Underlying().(*types.Interface)
+	return types.Implements(types.NewPointer(t), unmarshaler)
+}
+
+// Returns true if provided value is a number literal of given kind (INT or FLOAT)
+func isNumber(value string, kind token.Token) bool {
+	return parseNumber(value, kind).Kind() != constant.Unknown
+}
+
+// Returns the constant of number literal of given kind (INT or FLOAT) preceded by at most one sign.
+// Unknown constant is returned if the value is not such literal
+func parseNumber(value string, kind token.Token) constant.Value {
+	negative := strings.HasPrefix(value, "-")
+	if negative || strings.HasPrefix(value, "+") {
+		value = value[1:]
+	}
+	if strings.HasPrefix(value, "-") || strings.HasPrefix(value, "+") {
+		// literal parsing accepts the sign as well
+		return constant.MakeUnknown()
+	}
+	v := constant.MakeFromLiteral(value, kind, 0)
+	if negative && v.Kind() != constant.Unknown {
+		v = constant.UnaryOp(token.SUB, v, 0)
+	}
+	return v
+}
+
+// Generates the value of annotation field given by expression.
+// The expression is a type reference for the fields of reflect.Type,
+// the reference to the function for the fields of func type,
+// otherwise it is the constant expression
+func generateExpr(e *Expr, fieldType types.Type, aliases *importAliases) string {
+	if isReflectType(fieldType) {
+		return generateTypeReference(e, aliases)
+	}
+	if _, ok := fieldType.Underlying().(*types.Signature); ok {
+		return generateFuncReference(e, fieldType, aliases)
+	}
+	return generateConstant(e, fieldType, aliases)
+}
+
+// Generates the value of constant expression like 60*60 or the reference to the constant.
+// The value should be assignable to provided field type and representable by it
+func generateConstant(e *Expr, fieldType types.Type, aliases *importAliases) string {
+	x, err := parser.ParseExpr(e.Text)
+	if err != nil {
+		panic("Incorrect expression '" + e.Text + "': " + err.Error())
+	}
+	value, valueType := evalConstant(e, x)
+	if valueType != nil && !types.AssignableTo(valueType, fieldType) {
+		panic("Constant '" + e.Text + "' of type " + valueType.String() +
+			" can't be assigned to annotation field of type " + fieldType.String())
+	}
+	var basic *types.Basic
+	switch t := fieldType.Underlying().(type) {
+	case *types.Basic:
+		basic = t
+		converted := representAs(value, basic)
+		if converted == nil {
+			panic("Constant '" + e.Text + "' = " + value.ExactString() +
+				" overflows or can't be assigned to annotation field of type " + fieldType.String())
 		}
-	case *ast.ArrayType:
-		return "nil"
+		value = converted
+	case *types.Interface:
+		// constant of default type
 	default:
-		panic("Unsupported fied type in annotation definition")
+		panic("Constant '" + e.Text + "' can't be assigned to annotation field of type " + fieldType.String())
+	}
+	switch x.(type) {
+	case *ast.Ident, *ast.SelectorExpr:
+		// keep the reference to the constant if the registry can access it
+		if pck, name := resolveReference(e); ast.IsExported(name) || pck == aliases.own {
+			return getReference(pck, name, aliases)
+		}
 	}
+	return getConstantLiteral(value, basic)
 }
 
-// Returns the constructor expression which can create the value of given type
-func getFieldConstructor(e ast.Expr) string {
-	switch t := e.(type) {
-	case *ast.StarExpr:
-		switch t.X.(type) {
-		case *ast.StarExpr:
-			panic("Ponter on pointers is not supported in annotation struct")
-		case *ast.ArrayType:
-			panic("Pointer on arrays is not supported in annotation struct")
-		default:
-			return "&" + getFieldConstructor(t.X)
+// Generates the reference to the function given by expression.
+// The function signature should match provided field type
+func generateFuncReference(e *Expr, fieldType types.Type, aliases *importAliases) string {
+	pck, name := resolveReference(e)
+	obj := loadTypes(pck).Scope().Lookup(name)
+	if obj == nil {
+		panic("'" + e.Text + "' is not found in package '" + pck + "'")
+	}
+	f, ok := obj.(*types.Func)
+	if !ok {
+		panic("'" + e.Text + "' is not a function")
+	}
+	if !types.AssignableTo(f.Type(), fieldType) {
+		panic("Function '" + e.Text + "' with signature " + f.Type().String() +
+			" can't be assigned to annotation field of type " + fieldType.String())
+	}
+	return getReference(pck, name, aliases)
+}
+
+// Returns the code referencing the identifier from provided package
+// which is qualified unless it is the package of the registry.
+// Unexported identifiers of other packages can't be referenced
+func getReference(pck, name string, aliases *importAliases) string {
+	if !ast.IsExported(name) && pck != aliases.own {
+		panic("'" + name + "' is not exported from package '" + pck +
+			"' and can't be referenced by the registry of package '" + aliases.own + "'")
+	}
+	return aliases.qualifier(pck) + name
+}
+
+// Generates reflect.Type value for the type given by expression
+func generateTypeReference(e *Expr, aliases *importAliases) string {
+	x, err := parser.ParseExpr(e.Text)
+	if err != nil {
+		panic("Incorrect type expression '" + e.Text + "': " + err.Error())
+	}
+	typeName := getTypeExpr(x, e, aliases)
+	return aliases.qualifier("reflect") + "TypeOf((*" + typeName + ")(nil)).Elem()"
+}
+
+// Returns the type name given by type expression where package aliases
+// of annotated file are replaced by the aliases of generated registry.
+// Named types should be predeclared or declared in annotated package
+// or exported from the package imported into annotated file
+func getTypeExpr(x ast.Expr, e *Expr, aliases *importAliases) string {
+	switch t := x.(type) {
+	case *ast.Ident:
+		if _, ok := types.Universe.Lookup(t.Name).(*types.TypeName); ok {
+			return t.Name
+		}
+		if _, ok := loadTypes(e.Package).Scope().Lookup(t.Name).(*types.TypeName); ok {
+			return getReference(e.Package, t.Name, aliases)
+		}
+		panic("Type '" + t.Name + "' is not found in '" + e.Text + "'")
+	case *ast.SelectorExpr:
+		pck, name := resolveSelector(e, t)
+		if _, ok := loadTypes(pck).Scope().Lookup(name).(*types.TypeName); !ok {
+			panic("Type '" + name + "' is not found in package '" + pck + "'")
 		}
+		return getReference(pck, name, aliases)
+	case *ast.StarExpr:
+		return "*" + getTypeExpr(t.X, e, aliases)
 	case *ast.ArrayType:
-		switch elemType := t.Elt.(type) {
-		case *ast.StarExpr:
-			panic("Array of pointers is not supported in annotation struct")
-		case *ast.ArrayType:
-			panic("Array of arrays is not supported in annotation struct")
-		default:
-			return "[]" + getFieldConstructor(elemType)
+		if t.Len == nil {
+			return "[]" + getTypeExpr(t.Elt, e, aliases)
+		}
+		if l, ok := t.Len.(*ast.BasicLit); ok && l.Kind == token.INT {
+			return "[" + l.Value + "]" + getTypeExpr(t.Elt, e, aliases)
 		}
+	case *ast.MapType:
+		return "map[" + getTypeExpr(t.Key, e, aliases) + "]" + getTypeExpr(t.Value, e, aliases)
+	}
+	panic("'" + e.Text + "' is not supported as type reference")
+}
+
+// Returns true if provided type is reflect.Type
+func isReflectType(t types.Type) bool {
+	return isNamedType(t, "reflect", "Type")
+}
+
+// Returns full package name and the name of identifier referenced by expression.
+// Expression can be an identifier of the annotated package or exported
+// identifier of imported package qualified by its alias
+func resolveReference(e *Expr) (string, string) {
+	x, err := parser.ParseExpr(e.Text)
+	if err != nil {
+		panic("Incorrect expression '" + e.Text + "': " + err.Error())
+	}
+	switch t := x.(type) {
 	case *ast.Ident:
-		switch t.Name {
-		case "int", "int8", "int16", "int32", "int64",
-			"uint", "uint8", "uint16", "uint32", "uint64",
-			"float32", "float64", "byte", "rune", "string":
-			return t.Name + "{"
-		case "complex64", "complex128", "uintptr":
-			panic("Type '" + t.Name + "' is not supported in annotation struct")
-		default:
-			return t.Name + "{"
+		return e.Package, t.Name
+	case *ast.SelectorExpr:
+		return resolveSelector(e, t)
+	}
+	panic("Expression '" + e.Text + "' is not supported as annotation attribute value")
+}
+
+// Returns full package name and the name of identifier referenced by selector
+// like http.MethodGet, where the qualifier is an import alias of annotated file
+func resolveSelector(e *Expr, sel *ast.SelectorExpr) (string, string) {
+	q, ok := sel.X.(*ast.Ident)
+	if !ok {
+		panic("Expression '" + e.Text + "' is not supported as annotation attribute value")
+	}
+	pck, found := e.Imports[q.Name]
+	if !found {
+		panic("Unknown package qualifier '" + q.Name + "' in '" + e.Text + "'")
+	}
+	if !sel.Sel.IsExported() {
+		panic("'" + q.Name + "." + sel.Sel.Name + "' is not exported")
+	}
+	return pck, sel.Sel.Name
+}
+
+// Returns the list of packages where the struct of given annotation should be searched.
+// Qualified annotation is searched only in its own package, otherwise provided packages are used
+func annotationPackages(a *AnnotationDoc, possiblePackages []string) []string {
+	if a.Qualifier == "" {
+		return possiblePackages
+	}
+	if a.Package == "" {
+		// qualifier is not resolved
+		return nil
+	}
+	return []string{a.Package}
+}
+
+// Returns type information of the annotation struct, its package and the list of packages
+// imported by the package where that struct is defined.
+// The struct can be declared by type alias or by another struct type (type A B)
+func getAnnotationStruct(name string, possiblePackages []string) (*types.TypeName, string, []string) {
+	result, foundPackage, foundImports := findAnnotationStruct(name, possiblePackages)
+	if result != nil {
+		return result, foundPackage, foundImports
+	}
+	panic("Annotation source for '" + name + "' is not found")
+}
+
+// Searches the annotation struct among provided packages.
+// Returns the same as getAnnotationStruct but nil is returned
+// if the struct is not found
+func findAnnotationStruct(name string, possiblePackages []string) (*types.TypeName, string, []string) {
+	var result *types.TypeName
+	var foundPackage string
+	var foundImports []string
+	for _, pck := range possiblePackages {
+		// annotations are searched only in the packages from GOPATH
+		if len(findDirs(pck)) == 0 && !isExternalTest(pck) {
+			continue
 		}
-	default:
-		panic("Unsupported field type in annotation")
+		p := loadTypes(pck)
+		obj, ok := p.Scope().Lookup(name).(*types.TypeName)
+		if !ok {
+			continue
+		}
+		if _, ok := obj.Type().Underlying().(*types.Struct); !ok {
+			continue
+		}
+		if result != nil {
+			panicReason(name, pck, foundPackage)
+		}
+		result = obj
+		foundPackage = pck
+		for _, imp := range p.Imports() {
+			foundImports = append(foundImports, imp.Path())
+		}
+	}
+	return result, foundPackage, foundImports
+}
+
+func panicReason(name, pck, foundPackage string) {
+	panic("Ambiguous reference to annotation '" + name + "':\n" +
+		"It exists in packages '" + foundPackage + "' and '" + pck + "'" +
+		"\nUse qualified name like @<alias>." + name + " to choose one")
+}
+
+// Generates default value for the field given by its tag in form `default:"XXX"`
+// or zero value of the field type if there is no such tag.
+// Arrays and annotations in the tag are written in annotation syntax, like
+// `default:"{\"a\",\"b\"}"` or `default:"@Person(\"anon\")"`
+func getDefaultValue(f *types.Var, tag, packageName string, imports []string, aliases *importAliases, indent string) string {
+	value := reflect.StructTag(tag).Get("default")
+	if len(value) == 0 {
+		return getZeroValue(f.Type(), aliases)
+	}
+	if trimmed := strings.TrimSpace(value); strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "@") {
+		// annotations in default value are resolved in the scope of the field declaration
+		pck := f.Pkg().Path()
+		var pckImports []string
+		for _, imp := range loadTypes(pck).Imports() {
+			pckImports = append(pckImports, imp.Path())
+		}
+		return generateValue(parseDefaultValue(f, value), f.Type(), pck, pckImports, aliases, indent)
+	}
+	if !isExpression(value) && !isStringType(f.Type()) {
+		// plain default value is the text of string field, numbers and booleans are literals for other fields
+		return generateValue(Literal(value), f.Type(), packageName, imports, aliases, indent)
+	}
+	return generateValue(value, f.Type(), packageName, imports, aliases, indent)
+}
+
+// Returns true if provided type (or the type it points to) is a string type
+func isStringType(t types.Type) bool {
+	if p, ok := t.(*types.Pointer); ok {
+		t = p.Elem()
+	}
+	b, ok := t.Underlying().(*types.Basic)
+	return ok && b.Info()&types.IsString != 0
+}
+
+// Parses default value of the field written in annotation syntax.
+// Package qualifiers and expressions in the value get the scope
+// of the source file where the field is declared
+func parseDefaultValue(f *types.Var, value string) interface{} {
+	chars := []rune(value)
+	v, index := parseParamValue(chars, 0, len(chars))
+	if rest := strings.TrimSpace(string(chars[index:])); rest != "" {
+		panic("Unexpected '" + rest + "' after default value '" + value + "'")
+	}
+	source := typesFileSet.Position(f.Pos()).Filename
+	return resolveValueReferences(v, f.Pkg().Path(), getFileAliases(source), source)
+}
+
+// Returns literal representation of zero value for provided type
+func getZeroValue(t types.Type, aliases *importAliases) string {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsBoolean != 0:
+			return "false"
+		case u.Info()&types.IsString != 0:
+			return "\"\""
+		case u.Info()&types.IsNumeric != 0:
+			return "0"
+		}
+	case *types.Struct, *types.Array:
+		return getTypeName(t, aliases) + "{}"
 	}
+	return "nil"
 }